@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// colorValue is a flag holding when to color the sizes: auto, always or never.
+type colorValue string
+
+func (v *colorValue) String() string {
+	return string(*v)
+}
+
+func (v *colorValue) Set(mode string) error {
+	switch mode {
+	case "auto", "always", "never":
+		*v = colorValue(mode)
+		return nil
+	}
+	return fmt.Errorf("unknown color mode %q", mode)
+}
+
+// useColor is set if the sizes are colored, see setColor.
+var useColor bool
+
+// setColor decides whether to color the sizes written to out: always or never as the -color flag says,
+// or in auto mode only if out is a terminal and the NO_COLOR environment variable isn't set.
+func setColor(out *os.File) {
+	switch colorFlag {
+	case "always":
+		useColor = true
+	case "auto":
+		info, err := out.Stat()
+		useColor = err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("NO_COLOR") == ""
+	}
+}
+
+// ANSI escape sequences coloring the sizes by magnitude.
+const (
+	green  = "\033[32m"
+	yellow = "\033[33m"
+	red    = "\033[31m"
+	reset  = "\033[0m"
+)
+
+// coloredSize returns bytes formatted by formatSize for a table, colored green below 1 GB, yellow below 100 GB and
+// red above if sizes are colored.
+func coloredSize(bytes int64) string {
+	size := formatSize(bytes)
+	if unitFlag.size > 0 {
+		size = fmt.Sprintf("%*s", unitWidth, size)
+	}
+	if !useColor {
+		return size
+	}
+	color := red
+	switch {
+	case bytes < 1e9:
+		color = green
+	case bytes < 100e9:
+		color = yellow
+	}
+	return color + size + reset
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// dbBatch is the number of rows inserted by each INSERT statement written by writeSQL.
+const dbBatch = 500
+
+// dbSchema creates the tables of the -db database: a row per run, and a row per directory of each run.
+const dbSchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	started_at TEXT NOT NULL,
+	roots TEXT NOT NULL,
+	files INTEGER NOT NULL,
+	bytes INTEGER NOT NULL,
+	partial INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS entries (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	path TEXT NOT NULL,
+	bytes INTEGER NOT NULL,
+	files INTEGER NOT NULL,
+	dirs INTEGER NOT NULL,
+	PRIMARY KEY (run_id, path)
+);
+`
+
+// checkSQLite returns an error if the sqlite3 command line shell that reads and writes the -db database
+// isn't on the PATH.
+func checkSQLite() error {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("-db needs the sqlite3 command line shell of SQLite on the PATH, see https://sqlite.org/cli.html")
+	}
+	return nil
+}
+
+// sqlQuote returns s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Writes the SQL script recording the run started at start and its directory totals in a single transaction,
+// inserting the directories dbBatch at a time
+func writeSQL(w io.Writer, res du.Result, start time.Time) {
+	partial := 0
+	if res.Partial {
+		partial = 1
+	}
+	fmt.Fprintf(w, "%sBEGIN;\n", dbSchema)
+	fmt.Fprintf(w, "INSERT INTO runs (started_at, roots, files, bytes, partial) VALUES (%s, %s, %d, %d, %d);\n",
+		sqlQuote(start.UTC().Format(time.RFC3339)), sqlQuote(strings.Join(res.Roots, "\n")), res.Files, res.Bytes, partial)
+	paths := sortedBySize(res.Dirs)
+	for i, path := range paths {
+		if i%dbBatch == 0 {
+			fmt.Fprintf(w, "INSERT INTO entries (run_id, path, bytes, files, dirs) VALUES\n")
+		}
+		u := res.Dirs[path]
+		sep := ","
+		if i%dbBatch == dbBatch-1 || i == len(paths)-1 {
+			sep = ";"
+		}
+		fmt.Fprintf(w, "((SELECT max(id) FROM runs), %s, %d, %d, %d)%s\n", sqlQuote(path), u.Bytes, u.Files, u.Dirs, sep)
+	}
+	fmt.Fprintf(w, "COMMIT;\n")
+}
+
+// saveDB records the run in the SQLite database at path, piping the SQL script to the sqlite3 command.
+func saveDB(path string, res du.Result, start time.Time) error {
+	cmd := exec.Command("sqlite3", "-bail", path)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("running sqlite3: %v", err)
+	}
+	w := bufio.NewWriter(stdin)
+	writeSQL(w, res, start)
+	err = w.Flush()
+	if cerr := stdin.Close(); err == nil {
+		err = cerr
+	}
+	if werr := cmd.Wait(); err == nil {
+		err = werr
+	}
+	return err
+}
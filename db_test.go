@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// oddPaths are paths holding quotes, newlines and the separators of the sqlite3 ascii mode.
+var oddPaths = []string{"root", "root/it's", "root/''", "root/new\nline", "root/.shell\n.quit", "root/unit\x1fsep", "root/record\x1esep", "root/é"}
+
+func TestSQLQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"":          "''",
+		"a":         "'a'",
+		"it's":      "'it''s'",
+		"''":        "''''''",
+		"new\nline": "'new\nline'",
+		"\x1f\x1e":  "'\x1f\x1e'",
+	} {
+		if got := sqlQuote(s); got != want {
+			t.Errorf("sqlQuote(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestWriteSQL(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, n := range []int{0, 1, dbBatch - 1, dbBatch, dbBatch + 1, 2 * dbBatch} {
+		res := du.Result{Roots: []string{"root"}, Files: 3, Bytes: 42, Partial: true, Dirs: make(map[string]*du.Usage)}
+		for i := 0; i < n; i++ {
+			res.Dirs[fmt.Sprintf("root/%04d", i)] = &du.Usage{Bytes: int64(i), Files: 1, Dirs: 1}
+		}
+		var buf bytes.Buffer
+		writeSQL(&buf, res, start)
+		script := buf.String()
+		if !strings.HasPrefix(script, dbSchema+"BEGIN;\n") || !strings.HasSuffix(script, ";\nCOMMIT;\n") {
+			t.Errorf("%d directories: script not in a transaction:\n%s", n, script)
+		}
+		if !strings.Contains(script, "VALUES ('2026-01-02T03:04:05Z', 'root', 3, 42, 1);\n") {
+			t.Errorf("%d directories: run not inserted:\n%s", n, script)
+		}
+		if got, want := strings.Count(script, "INSERT INTO entries"), (n+dbBatch-1)/dbBatch; got != want {
+			t.Errorf("%d directories: got %d INSERT statements, want %d", n, got, want)
+		}
+		var rows, ends int
+		for _, line := range strings.Split(script, "\n") {
+			if strings.HasPrefix(line, "((SELECT max(id) FROM runs), ") {
+				rows++
+				if strings.HasSuffix(line, ";") {
+					ends++
+				}
+			}
+		}
+		if rows != n || ends != (n+dbBatch-1)/dbBatch {
+			t.Errorf("%d directories: got %d rows and %d statement ends", n, rows, ends)
+		}
+	}
+}
+
+func TestParsePreviousRun(t *testing.T) {
+	var out []byte
+	for i, path := range oddPaths {
+		out = append(out, fmt.Sprintf("2026-01-02T03:04:05Z\x1f%s\x1f%d\x1e", strings.ToUpper(hex.EncodeToString([]byte(path))), i)...)
+	}
+	run, err := parsePreviousRun(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.startedAt != "2026-01-02T03:04:05Z" || len(run.dirs) != len(oddPaths) {
+		t.Fatalf("got %q and %q, want %d directories", run.startedAt, run.dirs, len(oddPaths))
+	}
+	for i, path := range oddPaths {
+		if size, ok := run.dirs[path]; !ok || size != int64(i) {
+			t.Errorf("%q: got %d, %v, want %d", path, size, ok, i)
+		}
+	}
+	if run, err := parsePreviousRun(nil); run != nil || err != nil {
+		t.Errorf("got %v and %v without rows, want no run", run, err)
+	}
+	for _, out := range []string{"a\x1f61\x1e", "a\x1fzz\x1f1\x1e", "a\x1f61\x1fx\x1e"} {
+		if _, err := parsePreviousRun([]byte(out)); err == nil {
+			t.Errorf("%q: got no error", out)
+		}
+	}
+}
+
+func TestDBRoundTrip(t *testing.T) {
+	if checkSQLite() != nil {
+		t.Skip("no sqlite3 command")
+	}
+	path := filepath.Join(t.TempDir(), "godu.db")
+	res := du.Result{Roots: []string{"root"}, Dirs: make(map[string]*du.Usage)}
+	for i, dir := range oddPaths {
+		res.Dirs[dir] = &du.Usage{Bytes: int64(100 + i)}
+	}
+	for i := 0; i < dbBatch+10; i++ {
+		res.Dirs[fmt.Sprintf("root/%04d", i)] = &du.Usage{Bytes: int64(i)}
+	}
+	if err := saveDB(path, res, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	partial := du.Result{Roots: []string{"root"}, Partial: true, Dirs: map[string]*du.Usage{"root": {Bytes: 1}}}
+	if err := saveDB(path, partial, time.Unix(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	run, err := loadPreviousRun(path, []string{"root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run == nil || run.startedAt != "1970-01-01T00:00:01Z" || len(run.dirs) != len(res.Dirs) {
+		t.Fatalf("got %+v, want the complete run with %d directories", run, len(res.Dirs))
+	}
+	for dir, u := range res.Dirs {
+		if run.dirs[dir] != u.Bytes {
+			t.Errorf("%q: got %d, want %d", dir, run.dirs[dir], u.Bytes)
+		}
+	}
+	if run, err := loadPreviousRun(path, []string{"other"}); run != nil || err != nil {
+		t.Errorf("got %v and %v for other roots, want no run", run, err)
+	}
+}
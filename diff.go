@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// diffEntry is a file or a directory whose size differs between the root and the -diff tree.
+type diffEntry struct {
+	Path       string `json:"path"`   // path relative to both trees
+	Status     string `json:"status"` // "changed", "missing" from the -diff tree or "extra" in it
+	Dir        bool   `json:"dir"`
+	Bytes      int64  `json:"bytes"`
+	OtherBytes int64  `json:"other_bytes"`
+}
+
+// treeEntry is the size of a file or directory subtree of a tree compared by diffTrees.
+type treeEntry struct {
+	bytes int64
+	dir   bool
+}
+
+// treeEntries returns the sizes of the files and directory subtrees of the single root of res, by path relative to it.
+func treeEntries(res du.Result) map[string]treeEntry {
+	entries := make(map[string]treeEntry, len(res.Dirs)+len(res.FileSizes))
+	rel := func(path string) string {
+		if r, err := filepath.Rel(res.Roots[0], path); err == nil {
+			return r
+		}
+		return path
+	}
+	for path, u := range res.Dirs {
+		entries[rel(path)] = treeEntry{bytes: u.Bytes, dir: true}
+	}
+	for path, size := range res.FileSizes {
+		entries[rel(path)] = treeEntry{bytes: size}
+	}
+	return entries
+}
+
+// diffTrees returns the entries that differ between the trees walked in res and other, sorted by path.
+// The descendants of missing or extra directories are left out.
+func diffTrees(res, other du.Result) []diffEntry {
+	ours, theirs := treeEntries(res), treeEntries(other)
+	var diffs []diffEntry
+	for path, e := range ours {
+		o, ok := theirs[path]
+		switch {
+		case !ok:
+			if _, ok := theirs[filepath.Dir(path)]; ok {
+				diffs = append(diffs, diffEntry{Path: path, Status: "missing", Dir: e.dir, Bytes: e.bytes})
+			}
+		case e.dir != o.dir || e.bytes != o.bytes:
+			diffs = append(diffs, diffEntry{Path: path, Status: "changed", Dir: e.dir, Bytes: e.bytes, OtherBytes: o.bytes})
+		}
+	}
+	for path, o := range theirs {
+		if _, ok := ours[path]; !ok {
+			if _, ok := ours[filepath.Dir(path)]; ok {
+				diffs = append(diffs, diffEntry{Path: path, Status: "extra", Dir: o.dir, OtherBytes: o.bytes})
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
+
+// signedSize returns the size difference delta with its sign, e.g. "+1.5 MB".
+func signedSize(delta int64) string {
+	if delta < 0 {
+		return "-" + formatSize(-delta)
+	}
+	return "+" + formatSize(delta)
+}
+
+// Prints the files and directories whose size differs between the root walked in res and the -diff tree walked in other,
+// as a JSON object if invoked with -json flag
+func printDiff(w io.Writer, res, other du.Result) {
+	diffs := diffTrees(res, other)
+	if *jsonFlag {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		rep := struct {
+			Root    string      `json:"root"`
+			Other   string      `json:"other"`
+			Partial bool        `json:"partial"`
+			Diffs   []diffEntry `json:"diffs"`
+		}{res.Roots[0], other.Roots[0], res.Partial || other.Partial, diffs}
+		if rep.Diffs == nil {
+			rep.Diffs = []diffEntry{}
+		}
+		if err := enc.Encode(rep); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+		}
+		return
+	}
+	counts := make(map[string]int)
+	for _, d := range diffs {
+		path := d.Path
+		if d.Dir {
+			path += string(filepath.Separator)
+		}
+		switch d.Status {
+		case "changed":
+			fmt.Fprintf(w, "changed\t%s -> %s (%s)\t%s\n", formatSize(d.Bytes), formatSize(d.OtherBytes), signedSize(d.OtherBytes-d.Bytes), path)
+		case "missing":
+			fmt.Fprintf(w, "missing\t%s\t%s\n", formatSize(d.Bytes), path)
+		case "extra":
+			fmt.Fprintf(w, "extra\t%s\t%s\n", formatSize(d.OtherBytes), path)
+		}
+		counts[d.Status]++
+	}
+	status := "Done!"
+	if res.Partial || other.Partial {
+		status = "Interrupted! Partial differences:"
+	}
+	fmt.Fprintf(w, "\n%s\n%s vs %s: %d changed, %d missing, %d extra, Size: %s -> %s (%s)\n", status, res.Roots[0], other.Roots[0],
+		counts["changed"], counts["missing"], counts["extra"], formatSize(res.Bytes), formatSize(other.Bytes), signedSize(other.Bytes-res.Bytes))
+}
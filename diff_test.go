@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+func TestDiffTrees(t *testing.T) {
+	res := du.Result{
+		Roots: []string{"/src"},
+		Bytes: 600,
+		Dirs: map[string]*du.Usage{
+			"/src": {Bytes: 600}, "/src/sub": {Bytes: 300}, "/src/gone": {Bytes: 100}, "/src/same": {Bytes: 50},
+		},
+		FileSizes: map[string]int64{
+			"/src/a": 100, "/src/b": 50, "/src/sub/c": 300, "/src/gone/d": 100, "/src/same/e": 50, "/src/x": 0,
+		},
+	}
+	other := du.Result{
+		Roots: []string{"/mirror"},
+		Bytes: 530,
+		Dirs: map[string]*du.Usage{
+			"/mirror": {Bytes: 530}, "/mirror/sub": {Bytes: 250}, "/mirror/new": {Bytes: 70}, "/mirror/same": {Bytes: 50}, "/mirror/x": {},
+		},
+		FileSizes: map[string]int64{
+			"/mirror/a": 100, "/mirror/b": 60, "/mirror/sub/c": 250, "/mirror/new/f": 70, "/mirror/same/e": 50,
+		},
+	}
+	want := []diffEntry{
+		{Path: ".", Status: "changed", Dir: true, Bytes: 600, OtherBytes: 530},
+		{Path: "b", Status: "changed", Bytes: 50, OtherBytes: 60},
+		{Path: "gone", Status: "missing", Dir: true, Bytes: 100},  // without gone/d
+		{Path: "new", Status: "extra", Dir: true, OtherBytes: 70}, // without new/f
+		{Path: "sub", Status: "changed", Dir: true, Bytes: 300, OtherBytes: 250},
+		{Path: "sub/c", Status: "changed", Bytes: 300, OtherBytes: 250},
+		{Path: "x", Status: "changed"}, // a file in the root, a directory in the other tree
+	}
+	if got := diffTrees(res, other); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := diffTrees(res, res); got != nil {
+		t.Errorf("got %+v comparing a tree with itself, want no differences", got)
+	}
+	var buf bytes.Buffer
+	printDiff(&buf, res, other)
+	out := buf.String()
+	for _, want := range []string{"\ngone" + string(filepath.Separator) + "\n", "\nnew" + string(filepath.Separator) + "\n", "/src vs /mirror: 5 changed, 1 missing, 1 extra"} {
+		if !strings.Contains(strings.ReplaceAll(out, "\t", "\n"), want) {
+			t.Errorf("%q not printed in:\n%s", want, out)
+		}
+	}
+	if !strings.HasPrefix(out, "changed\t") || strings.Count(out, "\nmissing\t") != 1 || strings.Count(out, "\nextra\t") != 1 {
+		t.Errorf("got:\n%s", out)
+	}
+}
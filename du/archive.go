@@ -0,0 +1,193 @@
+package du
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveKind returns the kind of archive of a file named name if Archives is set: "tar", "tgz" or "zip",
+// or "" if it isn't one.
+func (w *walker) archiveKind(name string) string {
+	if !w.opts.Archives {
+		return ""
+	}
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// archiveEntry is a file or directory found in an archive.
+type archiveEntry struct {
+	name    string // slash-separated path within the archive
+	size    int64  // uncompressed size
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// archiveInfo is the file information of an archive entry of name, for FileFilter.
+type archiveInfo struct {
+	archiveEntry
+	name string
+}
+
+func (i archiveInfo) Name() string       { return i.name }
+func (i archiveInfo) Size() int64        { return i.size }
+func (i archiveInfo) Mode() os.FileMode  { return i.mode }
+func (i archiveInfo) ModTime() time.Time { return i.modTime }
+func (i archiveInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i archiveInfo) Sys() interface{}   { return nil }
+
+// walkArchive counts the entries of the archive at path in job.dir as the files and directories below a virtual
+// directory of the same path, streaming through it without extracting it, and returns the totals of that
+// virtual directory. ok is false if the file couldn't be read as an archive at all, so it is counted as a
+// plain file instead, while errors once some entries are counted are reported and leave the others out.
+func (w *walker) walkArchive(ctx context.Context, job dirJob, path, kind string) (u Usage, ok bool) {
+	f, err := w.fsys.Open(path)
+	if err != nil {
+		return u, false
+	}
+	defer f.Close()
+	dirs := map[string]*Usage{path: {Dirs: 1}}
+	depths := map[string]int{path: job.depth + 1}
+
+	add := func(e archiveEntry) {
+		p := w.join(path, e.name)
+		if p == path || !strings.HasPrefix(p, path+w.sep()) {
+			return // the archive itself, or outside of it
+		}
+		dir := w.parent(p)
+		w.archiveDir(dirs, depths, path, dir)
+		if e.mode.IsDir() {
+			w.archiveDir(dirs, depths, path, p)
+			return
+		}
+		name := p[len(w.parent(p))+1:]
+		if !w.nameMatches(name, p) || e.size < w.opts.MinSize || (w.opts.MaxSize > 0 && e.size > w.opts.MaxSize) {
+			return
+		}
+		if !w.opts.NewerThan.IsZero() && e.modTime.Before(w.opts.NewerThan) || !w.opts.OlderThan.IsZero() && !e.modTime.Before(w.opts.OlderThan) {
+			return
+		}
+		if w.opts.FileFilter != nil && !w.opts.FileFilter(name, archiveInfo{e, name}) {
+			return
+		}
+		size := w.roundBlock(e.size)
+		if w.opts.CountOnly || special(e.mode) {
+			size = 0
+		}
+		dirs[dir].Entries++
+		for d := dir; ; d = w.parent(d) {
+			dirs[d].Bytes += size
+			dirs[d].Files++
+			if e.mode&os.ModeSymlink != 0 {
+				dirs[d].Symlinks++
+			}
+			if d == path {
+				break
+			}
+		}
+		w.results <- result{root: job.root, dir: dir, path: p, depth: depths[dir], size: size, apparent: e.size, allocated: -1, mode: e.mode, modTime: e.modTime, empty: e.size == 0 && !w.opts.CountOnly}
+	}
+
+	switch kind {
+	case "zip":
+		ra, isReaderAt := f.(io.ReaderAt)
+		info, err := f.Stat()
+		if !isReaderAt || err != nil {
+			return u, false
+		}
+		zr, err := zip.NewReader(ra, info.Size())
+		if err != nil {
+			return u, false
+		}
+		for _, zf := range zr.File {
+			if ctx.Err() != nil {
+				break
+			}
+			add(archiveEntry{name: zf.Name, size: int64(zf.UncompressedSize64), mode: zf.Mode(), modTime: zf.Modified})
+		}
+	default:
+		var r io.Reader = f
+		if kind == "tgz" {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return u, false
+			}
+			defer gz.Close()
+			r = gz
+		}
+		tr := tar.NewReader(r)
+		for n := 0; ctx.Err() == nil; n++ {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if n == 0 {
+					return u, false
+				}
+				w.errs.add(path, err)
+				break
+			}
+			add(archiveEntry{name: hdr.Name, size: hdr.Size, mode: hdr.FileInfo().Mode(), modTime: hdr.ModTime})
+		}
+	}
+
+	// The virtual directories up to the archive, deepest first so each is complete before its parent
+	vdirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		vdirs = append(vdirs, dir)
+	}
+	sort.Slice(vdirs, func(i, j int) bool {
+		if depths[vdirs[i]] != depths[vdirs[j]] {
+			return depths[vdirs[i]] > depths[vdirs[j]]
+		}
+		return vdirs[i] < vdirs[j]
+	})
+	for _, dir := range vdirs {
+		d := dirs[dir]
+		w.results <- result{root: job.root, dir: dir, path: dir, depth: depths[dir], isDir: true, entries: d.Entries, empty: d.Entries == 0}
+		if dir != path {
+			dirs[w.parent(dir)].Dirs += d.Dirs
+		}
+		if job.tree != nil {
+			w.results <- result{root: job.root, dir: dir, path: dir, depth: depths[dir], size: d.Bytes, files: d.Files, dirs: d.Dirs, symlinks: d.Symlinks, entries: d.Entries, isDir: true, done: true}
+		}
+	}
+	return *dirs[path], true
+}
+
+// archiveDir records the virtual directory dir of the archive at path and its parents up to the archive,
+// counting each new one as an entry of its parent.
+func (w *walker) archiveDir(dirs map[string]*Usage, depths map[string]int, path, dir string) {
+	if _, ok := dirs[dir]; ok {
+		return
+	}
+	parent := w.parent(dir)
+	w.archiveDir(dirs, depths, path, parent)
+	dirs[dir] = &Usage{Dirs: 1}
+	depths[dir] = depths[parent] + 1
+	dirs[parent].Entries++
+}
+
+// sep returns the path separator of the file system walked.
+func (w *walker) sep() string {
+	if w.native() {
+		return string(os.PathSeparator)
+	}
+	return "/"
+}
@@ -0,0 +1,82 @@
+package du
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// tuneInterval is how often AutoTune samples the rate the directories are read at.
+const tuneInterval = 250 * time.Millisecond
+
+// autotune starts reading at most 2 directories at once, and raises that level by half at each sample while
+// the rate of directories read keeps improving by at least 10%, up to max. Once it stops improving it settles back
+// on the best level found. The directories that may not be read are held as tokens of sema,
+// so it must be called before the workers start.
+func (w *walker) autotune(ctx context.Context, max int) {
+	level := 2
+	if level > max {
+		level = max
+	}
+	for i := level; i < w.opts.MaxOpen; i++ {
+		w.sema <- struct{}{}
+	}
+	atomic.StoreInt64(&w.level, int64(level))
+	go func() {
+		ticker := time.NewTicker(tuneInterval)
+		defer ticker.Stop()
+		best, bestRate := level, 0.0
+		var last int64
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			read := atomic.LoadInt64(&w.read)
+			rate := float64(read-last) / tuneInterval.Seconds()
+			last = read
+			if rate == 0 {
+				continue // no directory read yet at this level
+			}
+			if rate <= bestRate*1.1 || level == max {
+				if rate > bestRate {
+					best = level
+				}
+				if w.setLevel(ctx, level, best) && w.opts.Logf != nil {
+					w.opts.Logf("autotune: settled on reading %d directories at once, %.0f directories/s at %d", best, rate, level)
+				}
+				return
+			}
+			best, bestRate = level, rate
+			next := level + (level+1)/2
+			if next > max {
+				next = max
+			}
+			if !w.setLevel(ctx, level, next) {
+				return
+			}
+			if w.opts.Logf != nil {
+				w.opts.Logf("autotune: reading %d directories at once, %.0f directories/s at %d", next, rate, level)
+			}
+			level = next
+		}
+	}()
+}
+
+// setLevel changes the number of directories read at once from level to next, releasing or taking back
+// the tokens of sema, and reports whether it did before ctx was cancelled.
+func (w *walker) setLevel(ctx context.Context, level, next int) bool {
+	for ; level < next; level++ {
+		<-w.sema
+	}
+	for ; level > next; level-- {
+		select {
+		case w.sema <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	atomic.StoreInt64(&w.level, int64(next))
+	return true
+}
@@ -0,0 +1,194 @@
+package du
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchTree creates a tree of dirs directories holding files empty files each below dir.
+func benchTree(b *testing.B, dirs, files int) string {
+	b.Helper()
+	root := b.TempDir()
+	for i := 0; i < dirs; i++ {
+		dir := filepath.Join(root, fmt.Sprint(i))
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < files; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprint(j)), nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+// BenchmarkWalkBuffer walks a tree of 20000 files with several sizes of the results channel buffer.
+func BenchmarkWalkBuffer(b *testing.B) {
+	root := benchTree(b, 200, 100)
+	for _, size := range []int{1, 16, 256, 4096} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Walk([]string{root}, Options{ResultBuffer: size}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(20000*b.N)/b.Elapsed().Seconds(), "files/s")
+		})
+	}
+}
+
+// BenchmarkCollector walks a tree of 50000 files with several numbers of workers, sending either a result
+// for each file to the collector or the totals of the files of each directory at once, to tell whether the
+// single collector bounds the throughput of many workers. MaxFiles is only set to force a result for each file.
+func BenchmarkCollector(b *testing.B) {
+	root := benchTree(b, 100, 500)
+	for _, threads := range []int{1, 4, 16, 64} {
+		for _, mode := range []struct {
+			name     string
+			maxFiles int64
+		}{{"per-file", math.MaxInt64}, {"batched", 0}} {
+			b.Run(fmt.Sprintf("%s/threads=%d", mode.name, threads), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := Walk([]string{root}, Options{Threads: threads, MaxFiles: mode.maxFiles}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(50000*b.N)/b.Elapsed().Seconds(), "files/s")
+			})
+		}
+	}
+}
+
+// BenchmarkPerDir walks a tree of 2000 directories holding 10 files each with PerDir and several numbers
+// of workers, the totals of the directories being rolled up either by the workers into a dirMap or by the
+// collector when MaxFiles needs a result for each file.
+func BenchmarkPerDir(b *testing.B) {
+	root := benchTree(b, 2000, 10)
+	for _, threads := range []int{1, 16, 64} {
+		for _, mode := range []struct {
+			name     string
+			maxFiles int64
+		}{{"collector", math.MaxInt64}, {"workers", 0}} {
+			b.Run(fmt.Sprintf("%s/threads=%d", mode.name, threads), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := Walk([]string{root}, Options{Threads: threads, PerDir: true, MaxDepth: -1, MaxFiles: mode.maxFiles}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(2000*b.N)/b.Elapsed().Seconds(), "dirs/s")
+			})
+		}
+	}
+}
+
+// walkPerDir walks dir like the walker did before it had a pool of workers, with a goroutine per subdirectory
+// and a semaphore only limiting the directories read at once, counting the files found in files.
+func walkPerDir(dir string, sema chan struct{}, files *int64, mu *sync.Mutex) {
+	sema <- struct{}{}
+	entries, _ := readDir(dir)
+	<-sema
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		if entry.IsDir() {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				walkPerDir(path, sema, files, mu)
+			}(filepath.Join(dir, entry.Name()))
+		} else {
+			mu.Lock()
+			*files++
+			mu.Unlock()
+		}
+	}
+	wg.Wait()
+}
+
+// peak is the highest number of goroutines and memory in use, heap and goroutine stacks, sampled during a walk.
+type peak struct {
+	goroutines int
+	inuse      uint64
+}
+
+// sample samples the number of goroutines and the memory in use until stop is closed, sending the peak on done.
+func sample(stop <-chan struct{}, done chan<- peak) {
+	var p peak
+	var m runtime.MemStats
+	for {
+		if n := runtime.NumGoroutine(); n > p.goroutines {
+			p.goroutines = n
+		}
+		if runtime.ReadMemStats(&m); m.HeapInuse+m.StackInuse > p.inuse {
+			p.inuse = m.HeapInuse + m.StackInuse
+		}
+		select {
+		case <-stop:
+			done <- p
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// BenchmarkWideTree compares the pool of workers walking a directory with 20000 subdirectories with the goroutine
+// per subdirectory it replaced, reporting the peak number of goroutines and memory in use of each. CountOnly
+// spares the pool the stat calls the other one doesn't make.
+// BenchmarkDirMapAdd adds the totals of a file to a directory of a dirMap, which the workers do for every file
+// and every parent directory with PerDir, and which shouldn't allocate once the directory is in the map.
+func BenchmarkDirMapAdd(b *testing.B) {
+	m := newDirMap()
+	dir := "root/some/deep/directory"
+	m.add(dir, Usage{}, false)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.add(dir, Usage{Bytes: 100, Files: 1}, false)
+	}
+}
+
+func BenchmarkWideTree(b *testing.B) {
+	root := benchTree(b, 20000, 1)
+	for _, bench := range []struct {
+		name string
+		walk func()
+	}{
+		{"pool", func() {
+			if _, err := Walk([]string{root}, Options{CountOnly: true}); err != nil {
+				b.Fatal(err)
+			}
+		}},
+		{"goroutine-per-dir", func() {
+			var files int64
+			var mu sync.Mutex
+			walkPerDir(root, make(chan struct{}, DefaultMaxOpen()), &files, &mu)
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var max peak
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				stop, done := make(chan struct{}), make(chan peak)
+				go sample(stop, done)
+				bench.walk()
+				close(stop)
+				p := <-done
+				if p.goroutines > max.goroutines {
+					max.goroutines = p.goroutines
+				}
+				if p.inuse > max.inuse {
+					max.inuse = p.inuse
+				}
+			}
+			b.ReportMetric(float64(max.goroutines), "peak-goroutines")
+			b.ReportMetric(float64(max.inuse), "peak-inuse-B")
+		})
+	}
+}
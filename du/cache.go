@@ -0,0 +1,139 @@
+package du
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Cache holds the totals of the files of each directory walked with it, along with the modification time of the
+// directory, so that the next walk with it can reuse the totals of the directories whose modification time didn't
+// change instead of reading them again. Only the subdirectories of a reused directory are stated, to check their
+// own modification times, so a subtree is only reused as a whole if none of its directories changed.
+//
+// Creating, removing or renaming an entry updates the modification time of its directory on most file systems,
+// but writing to a file in place doesn't, so the cached size of a file that grew or shrank since is reused until
+// an entry of its directory changes. Some network and FAT file systems also update modification times lazily or
+// with a coarse resolution, and changes within the same tick go unnoticed.
+//
+// The totals are only reused by walks with the same options counting them, e.g. Exclude and MinSize;
+// changing any of them starts afresh.
+type Cache struct {
+	mu   sync.Mutex
+	key  string               // options the cached totals were counted with
+	dirs map[string]cachedDir // directories of the previous walk
+	next map[string]cachedDir // directories of the walk in progress
+}
+
+// cachedDir is the cached totals of the files of a directory.
+type cachedDir struct {
+	ModTime  time.Time    `json:"mtime"`
+	Bytes    int64        `json:"bytes"` // total of the files with a single link, or of every file if CountLinks is set
+	Files    int64        `json:"files"`
+	Symlinks int64        `json:"symlinks,omitempty"` // symbolic links among Files
+	OnDisk   bool         `json:"on_disk,omitempty"`  // set if the sizes are allocated disk space
+	Entries  int64        `json:"entries"`
+	Subdirs  []string     `json:"subdirs,omitempty"` // names of the subdirectories walked
+	Links    []cachedLink `json:"links,omitempty"`   // files with several links, only counted for the first one found
+}
+
+// cachedLink is a cached file with several links.
+type cachedLink struct {
+	Dev     uint64 `json:"dev"`
+	Ino     uint64 `json:"ino"`
+	Size    int64  `json:"size"`
+	Symlink bool   `json:"symlink,omitempty"`
+}
+
+// cacheFile is the format of a saved Cache.
+type cacheFile struct {
+	Key  string               `json:"key"`
+	Dirs map[string]cachedDir `json:"dirs"`
+}
+
+// NewCache returns an empty cache.
+func NewCache() *Cache {
+	return &Cache{}
+}
+
+// LoadCache returns the cache saved to r by Save.
+func LoadCache(r io.Reader) (*Cache, error) {
+	var f cacheFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid cache: %v", err)
+	}
+	return &Cache{key: f.Key, dirs: f.Dirs}, nil
+}
+
+// Save writes the cache to w, to be loaded by LoadCache.
+func (c *Cache) Save(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.NewEncoder(w).Encode(cacheFile{Key: c.key, Dirs: c.dirs})
+}
+
+// begin starts a walk counting with the options summed up by key, dropping the cached directories
+// if they were counted with other options.
+func (c *Cache) begin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.key != key {
+		c.key, c.dirs = key, nil
+	}
+	c.next = make(map[string]cachedDir)
+}
+
+// end replaces the cached directories with those of the walk, keeping the other ones if it is partial.
+func (c *Cache) end(partial bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if partial {
+		for dir, d := range c.dirs {
+			if _, ok := c.next[dir]; !ok {
+				c.next[dir] = d
+			}
+		}
+	}
+	c.dirs, c.next = c.next, nil
+}
+
+// lookup returns the cached totals of dir if its modification time is still modTime.
+func (c *Cache) lookup(dir string, modTime time.Time) (cachedDir, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.dirs[dir]
+	return d, ok && !modTime.IsZero() && d.ModTime.Equal(modTime)
+}
+
+// store records the totals of dir counted by the walk.
+func (c *Cache) store(dir string, d cachedDir) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next[dir] = d
+}
+
+// cacheKey sums up the options changing the totals of the files of a directory.
+func cacheKey(opts Options) string {
+	var match, noMatch string
+	if opts.Match != nil {
+		match = opts.Match.String()
+	}
+	if opts.NoMatch != nil {
+		noMatch = opts.NoMatch.String()
+	}
+	key, _ := json.Marshal(struct {
+		CountLinks, Apparent, CountOnly, OneFileSystem, ExcludeHidden, IgnoreCase bool
+		BlockSize, MinSize, MaxSize                                               int64
+		Exclude, Only, IncludeExts, ExcludeExts, SkipMounts, CrossMounts          []string
+		Match, NoMatch                                                            string
+		NewerThan, OlderThan                                                      time.Time
+		UIDs, GIDs                                                                []uint32
+		PermAll, PermAny                                                          fs.FileMode
+	}{opts.CountLinks, opts.Apparent, opts.CountOnly, opts.OneFileSystem, opts.ExcludeHidden, opts.IgnoreCase, opts.BlockSize, opts.MinSize, opts.MaxSize,
+		opts.Exclude, opts.Only, opts.IncludeExts, opts.ExcludeExts, opts.SkipMounts, opts.CrossMounts, match, noMatch, opts.NewerThan, opts.OlderThan,
+		opts.UIDs, opts.GIDs, opts.PermAll, opts.PermAny})
+	return string(key)
+}
@@ -0,0 +1,54 @@
+package du
+
+import (
+	"crypto/sha256"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// contentDigest returns the SHA-256 digest of the contents of a regular file, of the target of a symbolic
+// link, or of nothing for the other kinds of files, which can't be read without blocking or side effects.
+// The targets of links are only read if fsys reports them.
+func contentDigest(fsys fs.FS, path string, info os.FileInfo) (sum [sha256.Size]byte, err error) {
+	links, ok := fsys.(readLinkFS)
+	switch {
+	case info.Mode().IsRegular():
+		return hashFile(fsys, path)
+	case info.Mode()&os.ModeSymlink != 0 && ok:
+		target, err := links.ReadLink(path)
+		if err != nil {
+			return sum, err
+		}
+		return sha256.Sum256([]byte(target)), nil
+	}
+	return sha256.Sum256(nil), nil
+}
+
+// fileDigest returns the digest of a file combined into Result.Checksum, covering both its path relative
+// to root and its contents, so renamed files change the checksum too.
+func fileDigest(root, path string, contents [sha256.Size]byte) [sha256.Size]byte {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	h := sha256.New()
+	h.Write([]byte(filepath.ToSlash(rel)))
+	h.Write([]byte{0})
+	h.Write(contents[:])
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// addDigest adds d to sum as 256-bit big-endian numbers, wrapping around on overflow. Unlike a hash of
+// the digests in sequence the result doesn't depend on the order the files are found in, and unlike
+// a XOR two identical digests don't cancel out.
+func addDigest(sum *[sha256.Size]byte, d [sha256.Size]byte) {
+	carry := 0
+	for i := len(sum) - 1; i >= 0; i-- {
+		v := int(sum[i]) + int(d[i]) + carry
+		sum[i] = byte(v)
+		carry = v >> 8
+	}
+}
@@ -0,0 +1,93 @@
+package du
+
+import "sync"
+
+// dirShards is the number of shards of a dirMap, enough for the workers to rarely wait for each other.
+const dirShards = 64
+
+// dirMap holds the totals of each directory like Result.Dirs, updated by the workers themselves under a lock
+// per shard of the directories, so that rolling the totals up the parent directories doesn't funnel through
+// the collector. It is merged into Result.Dirs once the walk is done.
+type dirMap struct {
+	shards [dirShards]struct {
+		mu   sync.Mutex
+		dirs map[string]*Usage
+	}
+}
+
+func newDirMap() *dirMap {
+	m := &dirMap{}
+	for i := range m.shards {
+		m.shards[i].dirs = make(map[string]*Usage)
+	}
+	return m
+}
+
+// shardOf returns the shard of dir, hashing it with FNV-1a in place rather than through hash/fnv,
+// which would allocate on every file.
+func shardOf(dir string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(dir); i++ {
+		h ^= uint32(dir[i])
+		h *= 16777619
+	}
+	return h % dirShards
+}
+
+// add adds the totals of add to those of dir, and sets its number of entries if isDir is set.
+func (m *dirMap) add(dir string, add Usage, isDir bool) {
+	s := &m.shards[shardOf(dir)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.dirs[dir]
+	if u == nil {
+		u = &Usage{}
+		s.dirs[dir] = u
+	}
+	u.Bytes += add.Bytes
+	u.Files += add.Files
+	u.Dirs += add.Dirs
+	u.Symlinks += add.Symlinks
+	if isDir {
+		u.Entries = add.Entries
+	}
+}
+
+// rollUp adds the totals of the directory or the files of r, a directory result or a batch one, to those
+// of its directory and of every parent directory up to the root, like walker.rollUp.
+func (m *dirMap) rollUp(w *walker, r result) {
+	add := Usage{Bytes: r.size, Files: r.files, Dirs: r.dirs, Symlinks: r.symlinks}
+	if r.isDir {
+		add = Usage{Dirs: 1}
+	}
+	w.upward(r, func(dir string) {
+		m.add(dir, add, false)
+	})
+	if r.isDir && w.shown(r.depth) {
+		m.add(r.dir, Usage{Entries: r.entries}, true)
+	}
+}
+
+// merge adds the totals of m to those of dirs, rolled up by the collector from the results sent without send,
+// e.g. those of archives. dirs isn't updated by the workers still running after a cancelled walk.
+func (m *dirMap) merge(dirs map[string]*Usage) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for dir, u := range s.dirs {
+			d := dirs[dir]
+			if d == nil {
+				d = &Usage{}
+				dirs[dir] = d
+			}
+			d.Bytes += u.Bytes
+			d.Files += u.Files
+			d.Dirs += u.Dirs
+			d.Symlinks += u.Symlinks
+			if u.Entries != 0 {
+				d.Entries = u.Entries
+			}
+		}
+		s.mu.Unlock()
+	}
+}
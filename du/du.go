@@ -0,0 +1,918 @@
+// Package du walks directory trees concurrently and in parallel, accumulating the number and size of the files found.
+//
+// All the roots of a walk share a single queue of directories and a fixed pool of Options.Threads workers,
+// so the number of goroutines stays bounded whatever the shape of the trees and the number of roots.
+package du
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Options configures a walk. The zero value walks with one worker per logical CPU, counting the disk space
+// allocated to each file and each hard linked file only once.
+type Options struct {
+	Threads       int      // number of workers walking directories, defaults to runtime.NumCPU(), or MaxOpen with AutoTune
+	MaxOpen       int      // number of directories read at once, defaults to DefaultMaxOpen()
+	ResultBuffer  int      // number of results buffered between the workers and the collector, defaults to 256
+	MaxRate       float64  // if set, the number of directories read and files whose contents are read per second
+	PerDir        bool     // accumulate the totals of every directory subtree in Result.Dirs
+	PerFile       bool     // keep the size of every file in Result.FileSizes
+	MaxDepth      int      // with PerDir, only keep directories at most MaxDepth levels below the roots, negative for no limit
+	MinDepth      int      // with PerDir, only keep directories at least MinDepth levels below the roots, the roots being at depth 0
+	MaxDirs       int      // with PerDir, only keep the MaxDirs largest completed subtrees, see Result.OtherDirs
+	Top           int      // keep the Top largest files in Result.TopFiles
+	TopDirs       int      // keep the TopDirs largest directory subtrees below the roots in Result.TopDirs
+	Sparse        int      // keep the Sparse files with the most unallocated space in Result.SparseFiles, see Result.SparseBytes
+	Exclude       []string // skip files and directories whose name or full path matches any of these shell patterns
+	ExcludeHidden bool     // skip files and directories whose name starts with a dot, or hidden by their attributes on Windows
+	Only          []string // only count the files whose full path matches any of these globs, ** matching any number of directories
+	IgnoreCase    bool     // match the Exclude patterns, the Only globs and the Match and NoMatch expressions case insensitively
+	GitIgnore     bool     // skip files and directories ignored by the .gitignore files found during the walk
+	CountLinks    bool     // count hard linked files once per link
+	Apparent      bool     // count apparent file sizes instead of allocated disk space
+	OneFileSystem bool     // skip directories on different file systems than their root, except those of CrossMounts
+	FollowLinks   bool     // follow symbolic links to files and directories within the same root
+	ByExt         bool     // accumulate the totals of every file extension in Result.Exts
+	ByOwner       bool     // accumulate the totals of every file owner in Result.Owners
+	ByDepth       bool     // accumulate the totals of every depth below the roots in Result.Depths
+	ByType        bool     // accumulate the totals of every type of file in Result.Types
+	ByDevice      bool     // accumulate the totals of every device holding files in Result.Devices, see Mounts
+	FindEmpty     bool     // list the empty directories and files in Result.EmptyDirs and Result.EmptyFiles
+	FindDupes     bool     // list the groups of files with identical contents in Result.Dupes
+	Checksum      bool     // read every file, combining the digests of their paths and contents in Result.Checksum
+	Median        bool     // estimate the median size of the files in Result.MedianSize
+	LongPaths     int      // list the files and directories whose path is longer than LongPaths characters in Result.LongPaths
+
+	// Sample, if between 0 and 1, only stats that fraction of the files picked at random, still counting every
+	// file from the directory entries, and estimates Result.Bytes and the total of each root from the mean size
+	// of the sampled files, see Result.SampleMargin. The hard links of the files not sampled can't be told apart.
+	// It can't be combined with the options needing every file like Cache, nor with the totals of the directories,
+	// archives and the filters on the sizes, times, owners and permissions of the files.
+	Sample float64
+
+	// Archives counts the contents of the .tar, .tar.gz, .tgz and .zip files found as virtual directories of the
+	// same path, e.g. the file inner/path of file.zip as file.zip/inner/path, with their uncompressed sizes,
+	// in place of the archive files themselves. The archives are streamed rather than extracted. Their files
+	// can't be read for FindDupes and Checksum.
+	Archives bool
+
+	// CountOnly only counts the files and directories, without the stat call per entry that sizes need,
+	// which is much faster on network file systems. Sizes, modification times and owners are all zero and
+	// hard links are counted once per link.
+	CountOnly bool
+
+	// Match and NoMatch, if set, only count the files whose name matches Match and doesn't match NoMatch.
+	// Directories are always walked.
+	Match, NoMatch *regexp.Regexp
+
+	// FileFilter, if set, only counts the files for which it returns true, called with their name and file
+	// information by the workers concurrently. It can't be combined with Cache nor Sample.
+	FileFilter func(name string, info fs.FileInfo) bool
+
+	// NewerThan and OlderThan, if set, only count the files modified at or after NewerThan and before OlderThan.
+	// Directories are always walked.
+	NewerThan, OlderThan time.Time
+
+	// UIDs and GIDs, if set, only count the files owned by one of UIDs and by one of GIDs, on platforms reporting
+	// the owners of files. Directories are always walked.
+	UIDs, GIDs []uint32
+
+	// PermAll and PermAny, if set, only count the files whose mode has all the permission bits of PermAll and
+	// at least one of those of PermAny, e.g. 0o002 for world-writable files. The bits are those of fs.ModePerm,
+	// fs.ModeSetuid, fs.ModeSetgid and fs.ModeSticky. Directories are always walked.
+	PermAll, PermAny fs.FileMode
+
+	// BlockSize, if set, rounds the size of each file up to a multiple of BlockSize before it is counted,
+	// files of size 0 staying at 0 as they use no blocks. It applies to allocated disk space as well as
+	// to apparent sizes, but the disk space allocated by most file systems is already a multiple of it.
+	BlockSize int64
+
+	// MaxFiles, if set, stops the walk once MaxFiles files are counted and another one is found, setting
+	// Result.LimitReached and returning the partial totals.
+	MaxFiles int64
+
+	// SkipMounts skips the directories on the file systems holding these paths, e.g. "/proc" or the mount point
+	// of a network share, while CrossMounts lets OneFileSystem walk the directories on those holding these paths.
+	// Both need the platform to report devices, and paths that can't be stated make the walk fail.
+	SkipMounts, CrossMounts []string
+
+	// IncludeExts and ExcludeExts, if set, only count the files whose extension is among IncludeExts and not among
+	// ExcludeExts, which wins for the extensions in both. Extensions match case insensitively, with or without
+	// their leading dot, e.g. "mp4" or ".MP4". Directories are always walked.
+	IncludeExts, ExcludeExts []string
+
+	// MinSize and MaxSize, if set, only count the files whose apparent size is at least MinSize and at most MaxSize.
+	MinSize, MaxSize int64
+
+	// AgeBuckets holds increasing file ages, accumulating in Result.Ages the totals of the files modified
+	// within each age range, plus those older than the last one.
+	AgeBuckets []time.Duration
+
+	// SizeBuckets holds increasing file sizes, accumulating in Result.Sizes the totals of the files whose
+	// apparent size is smaller than each size but not than the previous one, plus those at least as large
+	// as the last one.
+	SizeBuckets []int64
+
+	// FS is the file system walked if set, the roots and the reported paths then being slash-separated paths
+	// within it, as with fs.ValidPath, and sizes being apparent. Otherwise the operating system's file system
+	// is walked. FollowLinks needs the operating system's file system.
+	FS fs.FS
+
+	// Logf is called with a note about each entry skipped for a reason other than Exclude if set.
+	Logf func(format string, args ...interface{})
+
+	// Logger receives a debug record for each directory entered and read, with the duration of the read, a warning
+	// for each read lasting at least SlowRead (1s by default) and each unreadable entry, and a record for each
+	// skipped entry with the reason: debug for the filters, info for the reasons also noted on Logf, if set.
+	Logger   *slog.Logger
+	SlowRead time.Duration
+
+	// Retries is the number of times the read of a directory failing with a transient error, like EINTR or EIO
+	// on a flaky network mount, is retried before giving up, waiting RetryDelay (100ms by default) before the
+	// first retry and twice as long before each next one. It defaults to 3, negative for no retry. Permanent
+	// errors like ENOENT or EACCES aren't retried. Each retry is noted on Logf and logged as a warning on Logger.
+	Retries    int
+	RetryDelay time.Duration
+
+	// Cache, if set, reuses the totals of the directories cached by the previous walk with it whose modification
+	// time didn't change instead of reading them, and caches the totals of the directories of this walk for the
+	// next one, see Cache for the caveats. It can't be combined with the options needing every file, e.g. Top,
+	// nor with GitIgnore, Archives and FollowLinks.
+	Cache *Cache
+
+	// DirDone is called with the totals of each directory subtree as soon as it is completely walked if set,
+	// children before their parents. Directories outside the MinDepth and MaxDepth limits are left out. Unlike PerDir it doesn't
+	// keep the totals of every directory in memory. It is called from a single goroutine.
+	DirDone func(path string, u Usage)
+
+	// Checkpoint is called with the totals of each directory subtree directly below a root as soon as it is
+	// completely walked if set, whatever MaxDepth, from the same goroutine as DirDone, to record them for a
+	// later walk resuming an interrupted one with Completed.
+	Checkpoint func(path string, u Usage)
+
+	// Completed holds the totals of the directory subtrees already walked by an interrupted walk, which are
+	// counted with those totals instead of being walked again. Only these totals are known, so the files of
+	// the subtrees are left out of the options needing every file, e.g. Top, PerDir only has the totals of
+	// the subtrees themselves, and the files they share hard links with elsewhere are counted again.
+	Completed map[string]Usage
+
+	// Visit is called from a single goroutine, so it needs no locking, with an event for each file counted,
+	// for each directory subtree once it is completely walked, children before their parents and whatever
+	// MaxDepth, and for each directory or file that couldn't be read during the walk. Together with DirDone
+	// it streams the results without keeping them in memory like PerDir and PerFile.
+	Visit func(FileEvent)
+
+	// FileSum is called with the SHA-256 digest of the contents of each file if Checksum is set, from a single
+	// goroutine, in the order the files are found.
+	FileSum func(path string, sum [sha256.Size]byte)
+
+	// AutoTune, if set, starts reading 2 directories at once and raises that number while the rate of directories
+	// read keeps improving, up to the lowest of Threads and MaxOpen, settling on the best number found once it
+	// stops improving, noted on Logf and reported in Result.TunedReads. The best number differs widely between
+	// spinning disks, SSDs and network file systems.
+	AutoTune bool
+
+	// Progress is called with the running totals every ProgressInterval (500ms by default, never if negative) if set.
+	Progress         func(files, bytes int64)
+	ProgressInterval time.Duration
+
+	// ProgressNow, if set, calls DirProgress, Progress and RootProgress at once each time it receives a value,
+	// e.g. on a signal, on top of every ProgressInterval.
+	ProgressNow <-chan struct{}
+
+	// RootProgress is called with the running totals of each root, in the order of Result.Roots, every
+	// ProgressInterval if set, from the same goroutine as Progress.
+	RootProgress func(roots []string, totals []Usage)
+
+	// DirProgress is called with the number of directories found so far and the number of them already read
+	// every ProgressInterval if set, from the same goroutine as Progress and before it. The directories found
+	// but not read yet are the work known to be left, which the caller can use to estimate the time to finish.
+	DirProgress func(found, read int64)
+}
+
+// Result holds the totals of a walk.
+type Result struct {
+	Roots        []string          // cleaned paths of the roots walked
+	Files        int64             // number of files found
+	Directories  int64             // number of directories found, including the roots
+	Bytes        int64             // total size of the files found
+	PerRoot      map[string]*Usage // totals of each root
+	Dirs         map[string]*Usage // totals of every directory subtree if Options.PerDir is set
+	FileSizes    map[string]int64  // size of every file if Options.PerFile is set
+	TopFiles     []File            // the Options.Top largest files, largest first
+	TopDirs      []File            // the Options.TopDirs largest directory subtrees below the roots, whatever MaxDepth, largest first
+	Exts         map[string]*Usage // totals of every lowercased file extension if Options.ByExt is set, see NoExt
+	Owners       map[uint32]*Usage // totals of every owner user id if Options.ByOwner is set, on platforms reporting it
+	Types        map[string]*Usage // totals of every type of file if Options.ByType is set, see TypeRegular, directories counting in Dirs
+	Devices      map[uint64]*Usage // totals of the files on every device id if Options.ByDevice is set, on platforms reporting it
+	Ages         []AgeBucket       // totals by file age if Options.AgeBuckets is set, youngest first
+	Depths       []Usage           // totals of the directories at each depth and of their own files if Options.ByDepth is set, roots at 0
+	Sizes        []SizeBucket      // totals by file size if Options.SizeBuckets is set, smallest first
+	Partial      bool              // set if the walk was cancelled before completion
+	LimitReached bool              // set if the walk was stopped by Options.MaxFiles, Partial being set too
+	TunedReads   int               // number of directories read at once chosen by Options.AutoTune when the walk ended
+
+	// OtherDirs is the number of directories left out of Dirs by Options.MaxDirs, and OtherBytes the total size
+	// of their own files, not counting their subdirectories. Only completely walked subtrees are kept with MaxDirs,
+	// so the directories in progress when the walk is cancelled are in neither.
+	OtherDirs  int64
+	OtherBytes int64
+
+	// SampledFiles is the number of files stated if Options.Sample is set, and SampleMargin the margin of error
+	// of the estimated Bytes at 95% confidence, or -1 if fewer than 2 files were sampled.
+	SampledFiles int64
+	SampleMargin int64
+
+	// Apparent is set if the sizes are apparent file sizes rather than allocated disk space: if Options.Apparent
+	// or Options.FS is set, or if the platform didn't report the disk space of any of the files found.
+	Apparent bool
+
+	EmptyDirs  []string // sorted paths of the directories without entries if Options.FindEmpty is set
+	EmptyFiles []string // sorted paths of the files of apparent size 0 if Options.FindEmpty is set
+	LongPaths  []string // paths longer than Options.LongPaths characters, longest first
+
+	Dupes []DupeGroup // files with identical contents if Options.FindDupes is set, largest reclaimable space first
+
+	// Checksum is the sum of the SHA-256 digests of the path relative to its root and the contents of
+	// every file if Options.Checksum is set, the same for identical trees whatever the order of the walk.
+	// Files that couldn't be read are left out and reported in Errors.
+	Checksum [sha256.Size]byte
+
+	// SparseFiles holds the Options.Sparse regular files whose allocated disk space falls the most short of
+	// their apparent size, most first, on platforms reporting it. SparseBytes is the total of that shortfall
+	// over all the files, and SlackBytes the total of the disk space allocated beyond the apparent size of
+	// the other files, wasted by block or cluster rounding.
+	SparseFiles             []File
+	SparseBytes, SlackBytes int64
+
+	// MedianSize is the median size of the files if Options.Median is set, estimated within 3% from a histogram
+	// of the sizes rather than by keeping them all. The mean size is simply Bytes/Files.
+	MedianSize int64
+
+	Errors []Error // directories and files that couldn't be read, sorted by path
+}
+
+// Error is a directory that couldn't be read during the walk, its files are missing from the totals,
+// or a file that couldn't be read while looking for duplicates or computing the checksum.
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e Error) Error() string {
+	return e.Err.Error()
+}
+
+// NoExt is the key of Result.Exts for files without an extension.
+const NoExt = "<none>"
+
+// PermBits are the bits of the mode of a file matched by Options.PermAll and Options.PermAny.
+const PermBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Keys of Result.Types. Device files, sockets, named pipes and other irregular files always count for 0 bytes,
+// as they use no data blocks of their own. Dangling symbolic links are those whose target doesn't exist.
+const (
+	TypeRegular  = "regular"
+	TypeDir      = "dir"
+	TypeSymlink  = "symlink"
+	TypeDangling = "dangling symlink"
+	TypeDevice   = "device"
+	TypeSocket   = "socket"
+	TypePipe     = "pipe"
+	TypeOther    = "other"
+)
+
+// fileType returns the key of Result.Types for a file of mode.
+func fileType(mode fs.FileMode, dangling bool) string {
+	switch {
+	case mode.IsRegular():
+		return TypeRegular
+	case mode.IsDir():
+		return TypeDir
+	case mode&fs.ModeSymlink != 0 && dangling:
+		return TypeDangling
+	case mode&fs.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&fs.ModeDevice != 0:
+		return TypeDevice
+	case mode&fs.ModeSocket != 0:
+		return TypeSocket
+	case mode&fs.ModeNamedPipe != 0:
+		return TypePipe
+	}
+	return TypeOther
+}
+
+// special reports whether a file of mode is a device file, a socket, a named pipe or another irregular file.
+func special(mode fs.FileMode) bool {
+	return !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0
+}
+
+// Usage holds the accumulated totals of a directory subtree.
+type Usage struct {
+	Bytes int64
+	Files int64
+	Dirs  int64 // number of directories in the subtree, including the directory itself
+
+	// Symlinks is the number of symbolic links among Files, zero unless counting the files of a subtree or root.
+	Symlinks int64
+
+	// Entries is the number of entries of the directory itself, whatever the filters, zero for other totals.
+	Entries int64
+}
+
+// FileEvent is an event of the walk passed to Options.Visit: a file with its size, a directory with the total
+// size of its subtree, or an entry that couldn't be read with Err set. The size of a file whose contents
+// couldn't be read for Options.Checksum is set along with Err, as it is counted anyway.
+type FileEvent struct {
+	Path    string
+	Size    int64
+	IsDir   bool
+	Err     error
+	ModTime time.Time   // modification time of a file, zero with Options.CountOnly
+	Mode    fs.FileMode // mode of a file, only holding its type with Options.CountOnly
+}
+
+// AgeBucket holds the totals of the files modified less than Max ago, but not within the previous bucket.
+// Max is zero for the last bucket holding the files at least as old as all the others' Max.
+type AgeBucket struct {
+	Max time.Duration
+	Usage
+}
+
+// SizeBucket holds the totals of the files smaller than Max, but not within the previous bucket.
+// Max is zero for the last bucket holding the files at least as large as all the others' Max.
+type SizeBucket struct {
+	Max int64
+	Usage
+}
+
+// File is a file reported in Result.TopFiles or Result.SparseFiles, or a directory in Result.TopDirs
+// with the total size of its subtree.
+type File struct {
+	Path string
+	Size int64
+
+	// Apparent and Allocated are the apparent size and the allocated disk space of a file in
+	// Result.SparseFiles, whose Size is the difference between the two.
+	Apparent, Allocated int64
+}
+
+// DefaultMaxOpen returns the default number of directories read at once: 256, or half of the open files
+// limit of the process if that is lower, leaving room for the other files it has open.
+func DefaultMaxOpen() int {
+	max := uint64(256)
+	if limit, ok := openFilesLimit(); ok && limit/2 < max {
+		max = limit / 2
+	}
+	if max < 1 {
+		max = 1
+	}
+	return int(max)
+}
+
+// Walk walks the file trees rooted at roots and returns their totals.
+func Walk(roots []string, opts Options) (Result, error) {
+	return WalkContext(context.Background(), roots, opts)
+}
+
+// WalkContext is like Walk but stops when ctx is cancelled, returning the partial totals counted so far.
+func WalkContext(ctx context.Context, roots []string, opts Options) (Result, error) {
+	for _, pattern := range opts.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return Result{}, fmt.Errorf("exclude pattern %q: %v", pattern, err)
+		}
+	}
+	if opts.IgnoreCase {
+		opts.Exclude = lowerAll(opts.Exclude)
+		opts.Match, opts.NoMatch = foldCase(opts.Match), foldCase(opts.NoMatch)
+	}
+	if !opts.NewerThan.IsZero() && !opts.OlderThan.IsZero() && !opts.NewerThan.Before(opts.OlderThan) {
+		return Result{}, fmt.Errorf("the newer than time must be before the older than time")
+	}
+	if opts.CountOnly && (!opts.NewerThan.IsZero() || !opts.OlderThan.IsZero()) {
+		return Result{}, fmt.Errorf("modification time filters can't be combined with counting only, which skips the file times")
+	}
+	if opts.CountOnly && (opts.UIDs != nil || opts.GIDs != nil || opts.PermAll != 0 || opts.PermAny != 0) {
+		return Result{}, fmt.Errorf("owner and permission filters can't be combined with counting only, which skips the file modes and owners")
+	}
+	if opts.CountOnly && (opts.MinSize > 0 || opts.MaxSize > 0 || opts.FileFilter != nil || opts.SizeBuckets != nil) {
+		return Result{}, fmt.Errorf("size filters, file filters and size buckets can't be combined with counting only, which skips the file sizes")
+	}
+	if (opts.PermAll|opts.PermAny)&^PermBits != 0 {
+		return Result{}, fmt.Errorf("permission filters must only hold the permission, setuid, setgid and sticky bits")
+	}
+	if opts.BlockSize < 0 {
+		return Result{}, fmt.Errorf("block size must not be negative")
+	}
+	if opts.MaxFiles < 0 {
+		return Result{}, fmt.Errorf("file limit must not be negative")
+	}
+	if opts.MaxDirs < 0 {
+		return Result{}, fmt.Errorf("directory limit must not be negative")
+	}
+	if opts.MinSize < 0 || opts.MaxSize < 0 || (opts.MaxSize > 0 && opts.MinSize > opts.MaxSize) {
+		return Result{}, fmt.Errorf("file size limits must not be negative, and the minimum must not exceed the maximum")
+	}
+	if opts.MaxOpen <= 0 {
+		opts.MaxOpen = DefaultMaxOpen()
+	}
+	if opts.Threads <= 0 && opts.AutoTune {
+		opts.Threads = opts.MaxOpen
+	} else if opts.Threads <= 0 {
+		opts.Threads = runtime.NumCPU()
+	}
+	if opts.ResultBuffer <= 0 {
+		opts.ResultBuffer = 256
+	}
+	if opts.ProgressInterval == 0 {
+		opts.ProgressInterval = 500 * time.Millisecond
+	}
+	if opts.SlowRead <= 0 {
+		opts.SlowRead = time.Second
+	}
+	if opts.Retries == 0 {
+		opts.Retries = 3
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 100 * time.Millisecond
+	}
+
+	for i, age := range opts.AgeBuckets {
+		if age <= 0 || (i > 0 && age <= opts.AgeBuckets[i-1]) {
+			return Result{}, fmt.Errorf("age buckets must be positive and increasing")
+		}
+	}
+	for i, size := range opts.SizeBuckets {
+		if size <= 0 || (i > 0 && size <= opts.SizeBuckets[i-1]) {
+			return Result{}, fmt.Errorf("size buckets must be positive and increasing")
+		}
+	}
+
+	if opts.FS != nil && opts.FollowLinks {
+		return Result{}, fmt.Errorf("following symbolic links needs the operating system's file system")
+	}
+	if opts.Cache != nil && (needsEveryFile(opts) || opts.GitIgnore || opts.Archives || opts.FollowLinks || opts.FileFilter != nil) {
+		return Result{}, fmt.Errorf("the cache can't be combined with the options needing every file, .gitignore files, archives, following symbolic links or a file filter")
+	}
+	if opts.Sample < 0 || opts.Sample > 1 {
+		return Result{}, fmt.Errorf("sample rate must be between 0 and 1")
+	}
+	if opts.Sample > 0 && (needsEveryFile(opts) || opts.Cache != nil || opts.PerDir || opts.DirDone != nil || opts.Checkpoint != nil || opts.Completed != nil ||
+		opts.TopDirs > 0 || opts.ByDepth || opts.Archives || opts.CountOnly || opts.MinSize > 0 || opts.MaxSize > 0 || !opts.NewerThan.IsZero() ||
+		!opts.OlderThan.IsZero() || opts.UIDs != nil || opts.GIDs != nil || opts.PermAll != 0 || opts.PermAny != 0 || opts.FileFilter != nil) {
+		return Result{}, fmt.Errorf("sampling can't be combined with the options needing every file, the totals of the directories, archives, counting only or the filters on the sizes, times, owners and permissions of the files")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := newWalker(opts)
+	var err error
+	if w.skipDevs, err = w.devices(opts.SkipMounts); err != nil {
+		return Result{}, err
+	}
+	if w.crossDevs, err = w.devices(opts.CrossMounts); err != nil {
+		return Result{}, err
+	}
+	if w.only, err = compileGlobs(opts.Only, opts.IgnoreCase); err != nil {
+		return Result{}, err
+	}
+	res := Result{Roots: make([]string, len(roots))}
+	for i, root := range roots {
+		res.Roots[i] = w.clean(root)
+	}
+	if opts.Cache != nil {
+		opts.Cache.begin(cacheKey(opts))
+	}
+	w.start(ctx, res.Roots)
+	bySize := w.collect(ctx, cancel, &res)
+	if opts.FindDupes {
+		res.Dupes = w.findDupes(ctx, bySize)
+	}
+	res.Partial = ctx.Err() != nil
+	if opts.Cache != nil {
+		opts.Cache.end(res.Partial)
+	}
+	res.Errors = w.errs.list()
+	sort.SliceStable(res.Errors, func(i, j int) bool {
+		return res.Errors[i].Path < res.Errors[j].Path
+	})
+	return res, nil
+}
+
+// collect builds up the totals in res from the results of the walk until it is done, or until ctx is cancelled
+// without waiting for the workers that may be stuck reading a dead mount, whose results are then discarded.
+// It calls cancel once MaxFiles is exceeded. If FindDupes is set it returns the paths of the regular files
+// found by apparent size.
+func (w *walker) collect(ctx context.Context, cancel context.CancelFunc, res *Result) map[int64][]string {
+	// If a Progress function was provided, periodically call it with the running totals
+	var tick <-chan time.Time
+	if (w.opts.Progress != nil || w.opts.RootProgress != nil || w.opts.DirProgress != nil) && w.opts.ProgressInterval > 0 {
+		ticker := time.NewTicker(w.opts.ProgressInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	res.PerRoot = make(map[string]*Usage)
+	for _, root := range res.Roots {
+		res.PerRoot[root] = &Usage{}
+	}
+	var bounded *boundedDirs
+	if w.opts.PerDir && w.opts.MaxDirs > 0 {
+		bounded = newBoundedDirs(w.opts.MaxDirs)
+	} else if w.opts.PerDir {
+		res.Dirs = make(map[string]*Usage)
+	}
+	if w.opts.PerFile {
+		res.FileSizes = make(map[string]int64)
+	}
+	if w.opts.ByExt {
+		res.Exts = make(map[string]*Usage)
+	}
+	if w.opts.ByOwner {
+		res.Owners = make(map[uint32]*Usage)
+	}
+	if w.opts.ByType {
+		res.Types = make(map[string]*Usage)
+	}
+	if w.opts.ByDevice {
+		res.Devices = make(map[uint64]*Usage)
+	}
+	if len(w.opts.AgeBuckets) > 0 {
+		res.Ages = make([]AgeBucket, len(w.opts.AgeBuckets)+1)
+		for i, age := range w.opts.AgeBuckets {
+			res.Ages[i].Max = age
+		}
+	}
+	if len(w.opts.SizeBuckets) > 0 {
+		res.Sizes = make([]SizeBucket, len(w.opts.SizeBuckets)+1)
+		for i, size := range w.opts.SizeBuckets {
+			res.Sizes[i].Max = size
+		}
+	}
+	now := time.Now()
+	var top, topDirs, sparse topFiles
+	var onDisk int64                  // number of files sized by their allocated disk space
+	var squares float64               // sum of the squared sizes of the sampled files if Sample is set
+	rootSampled := map[string]int64{} // number of sampled files of each root if Sample is set
+	var bySize map[int64][]string
+	if w.opts.FindDupes {
+		bySize = make(map[int64][]string)
+	}
+	var sizes *sizeHistogram
+	if w.opts.Median {
+		sizes = &sizeHistogram{}
+	}
+
+loop:
+	for {
+		select {
+		case r, ok := <-w.results:
+			if !ok {
+				break loop // results was closed
+			}
+			if r.failed {
+				if w.opts.Visit != nil {
+					w.opts.Visit(FileEvent{Path: r.path, Err: r.err})
+				}
+				continue
+			}
+			if r.done {
+				if bounded != nil {
+					bounded.done(w, r)
+				}
+				u := Usage{Bytes: r.size, Files: r.files, Dirs: r.dirs, Entries: r.entries, Symlinks: r.symlinks}
+				if w.opts.DirDone != nil && w.shown(r.depth) {
+					w.opts.DirDone(r.dir, u)
+				}
+				if w.opts.Checkpoint != nil && r.depth == 1 {
+					w.opts.Checkpoint(r.dir, u)
+				}
+				if w.opts.Visit != nil {
+					w.opts.Visit(FileEvent{Path: r.dir, Size: r.size, IsDir: true})
+				}
+				if w.opts.TopDirs > 0 && r.depth > 0 {
+					topDirs.offer(File{Path: r.dir, Size: r.size}, w.opts.TopDirs)
+				}
+				continue
+			}
+			if r.empty && w.opts.FindEmpty {
+				if r.isDir {
+					res.EmptyDirs = append(res.EmptyDirs, r.path)
+				} else {
+					res.EmptyFiles = append(res.EmptyFiles, r.path)
+				}
+			}
+			if w.opts.LongPaths > 0 && !r.batch && utf8.RuneCountInString(r.path) > w.opts.LongPaths {
+				res.LongPaths = append(res.LongPaths, r.path)
+			}
+			if w.opts.ByDepth {
+				for len(res.Depths) <= r.depth {
+					res.Depths = append(res.Depths, Usage{})
+				}
+			}
+			if r.isDir {
+				if r.err != nil && w.opts.Visit != nil {
+					w.opts.Visit(FileEvent{Path: r.path, IsDir: true, Err: r.err})
+				}
+				if w.opts.ByDepth {
+					res.Depths[r.depth].Dirs++
+				}
+				if res.Types != nil {
+					typeUsage(res.Types, TypeDir).Dirs++
+				}
+				res.Directories++
+				res.PerRoot[r.root].Dirs++
+				if res.Dirs != nil && !r.rolledUp {
+					w.rollUp(res.Dirs, r, Usage{Dirs: 1})
+					if u := res.Dirs[r.dir]; u != nil {
+						u.Entries = r.entries
+					}
+				}
+				continue
+			}
+			if w.opts.MaxFiles > 0 && res.Files >= w.opts.MaxFiles {
+				res.LimitReached = true
+				cancel()
+				continue
+			}
+			res.SampledFiles += r.sampled
+			rootSampled[r.root] += r.sampled
+			squares += r.squares
+			if r.batch {
+				res.Files += r.files
+				res.Bytes += r.size
+				if r.onDisk {
+					onDisk += r.files
+				}
+				res.PerRoot[r.root].Bytes += r.size
+				res.PerRoot[r.root].Files += r.files
+				res.PerRoot[r.root].Symlinks += r.symlinks
+				res.Directories += r.dirs
+				res.PerRoot[r.root].Dirs += r.dirs
+				if res.Dirs != nil && !r.rolledUp {
+					w.rollUp(res.Dirs, r, Usage{Bytes: r.size, Files: r.files, Dirs: r.dirs, Symlinks: r.symlinks})
+				}
+				if w.opts.ByDepth {
+					res.Depths[r.depth].Bytes += r.size
+					res.Depths[r.depth].Files += r.files
+					res.Depths[r.depth].Dirs += r.dirs
+				}
+				continue
+			}
+			res.Files++
+			res.Bytes += r.size
+			if w.opts.Visit != nil {
+				w.opts.Visit(FileEvent{Path: r.path, Size: r.size, Err: r.err, ModTime: r.modTime, Mode: r.mode})
+			}
+			if r.onDisk {
+				onDisk++
+			}
+			var symlinks int64
+			if r.mode&fs.ModeSymlink != 0 {
+				symlinks = 1
+			}
+			res.PerRoot[r.root].Bytes += r.size
+			res.PerRoot[r.root].Files++
+			res.PerRoot[r.root].Symlinks += symlinks
+			if res.Dirs != nil {
+				w.rollUp(res.Dirs, r, Usage{Bytes: r.size, Files: 1, Symlinks: symlinks})
+			}
+			if res.FileSizes != nil {
+				res.FileSizes[r.path] = r.size
+			}
+			if res.Exts != nil {
+				u := res.Exts[ext(r.path)]
+				if u == nil {
+					u = &Usage{}
+					res.Exts[ext(r.path)] = u
+				}
+				u.Bytes += r.size
+				u.Files++
+			}
+			if res.Types != nil {
+				u := typeUsage(res.Types, fileType(r.mode, r.dangling))
+				u.Bytes += r.size
+				u.Files++
+			}
+			if res.Devices != nil && r.hasDev {
+				u := res.Devices[r.dev]
+				if u == nil {
+					u = &Usage{}
+					res.Devices[r.dev] = u
+				}
+				u.Bytes += r.size
+				u.Files++
+			}
+			if res.Owners != nil && r.owned {
+				u := res.Owners[r.uid]
+				if u == nil {
+					u = &Usage{}
+					res.Owners[r.uid] = u
+				}
+				u.Bytes += r.size
+				u.Files++
+			}
+			if w.opts.ByDepth {
+				res.Depths[r.depth].Bytes += r.size
+				res.Depths[r.depth].Files++
+			}
+			if res.Ages != nil {
+				b := ageBucket(res.Ages, now.Sub(r.modTime))
+				b.Bytes += r.size
+				b.Files++
+			}
+			if res.Sizes != nil {
+				b := sizeBucket(res.Sizes, r.apparent)
+				b.Bytes += r.size
+				b.Files++
+			}
+			if r.hashed {
+				addDigest(&res.Checksum, fileDigest(r.root, r.path, r.sum))
+				if w.opts.FileSum != nil {
+					w.opts.FileSum(r.path, r.sum)
+				}
+			}
+			if bySize != nil && r.regular {
+				bySize[r.apparent] = append(bySize[r.apparent], r.path)
+			}
+			if r.allocated >= 0 && w.opts.Sparse > 0 {
+				if gap := r.apparent - r.allocated; gap > 0 {
+					res.SparseBytes += gap
+					sparse.offer(File{Path: r.path, Size: gap, Apparent: r.apparent, Allocated: r.allocated}, w.opts.Sparse)
+				} else {
+					res.SlackBytes -= gap
+				}
+			}
+			if w.opts.Top > 0 {
+				top.offer(File{Path: r.path, Size: r.size}, w.opts.Top)
+			}
+			if sizes != nil {
+				sizes.add(r.size)
+			}
+		case <-tick:
+			w.progress(res)
+		case <-w.opts.ProgressNow:
+			w.progress(res)
+		case <-ctx.Done():
+			go func() {
+				for range w.results {
+				}
+			}()
+			break loop
+		}
+	}
+	res.Apparent = w.opts.Apparent || !w.native() || (res.Files > 0 && onDisk == 0)
+	res.TunedReads = int(atomic.LoadInt64(&w.level))
+	if w.opts.Sample > 0 {
+		extrapolate(res, rootSampled, squares)
+	}
+	if w.dirs != nil {
+		w.dirs.merge(res.Dirs)
+	}
+	if bounded != nil {
+		res.Dirs, res.OtherDirs, res.OtherBytes = bounded.dirs, bounded.others, bounded.bytes
+	}
+	res.TopFiles = top.sorted()
+	res.TopDirs = topDirs.sorted()
+	res.SparseFiles = sparse.sorted()
+	if sizes != nil {
+		res.MedianSize = sizes.median()
+	}
+	sort.Strings(res.EmptyDirs)
+	sort.Strings(res.EmptyFiles)
+	sort.Slice(res.LongPaths, func(i, j int) bool {
+		if li, lj := utf8.RuneCountInString(res.LongPaths[i]), utf8.RuneCountInString(res.LongPaths[j]); li != lj {
+			return li > lj
+		}
+		return res.LongPaths[i] < res.LongPaths[j]
+	})
+	return bySize
+}
+
+// typeUsage returns the totals of typ in types, adding them if needed.
+func typeUsage(types map[string]*Usage, typ string) *Usage {
+	u := types[typ]
+	if u == nil {
+		u = &Usage{}
+		types[typ] = u
+	}
+	return u
+}
+
+// ageBucket returns the bucket of ages holding files of age.
+func ageBucket(ages []AgeBucket, age time.Duration) *AgeBucket {
+	for i := range ages[:len(ages)-1] {
+		if age < ages[i].Max {
+			return &ages[i]
+		}
+	}
+	return &ages[len(ages)-1]
+}
+
+// sizeBucket returns the bucket of sizes holding files of size.
+func sizeBucket(sizes []SizeBucket, size int64) *SizeBucket {
+	for i := range sizes[:len(sizes)-1] {
+		if size < sizes[i].Max {
+			return &sizes[i]
+		}
+	}
+	return &sizes[len(sizes)-1]
+}
+
+// needsEveryFile reports whether opts need a result for each file, to keep or group the files themselves.
+func needsEveryFile(opts Options) bool {
+	return opts.PerFile || opts.Top > 0 || opts.Sparse > 0 || opts.ByExt || opts.ByOwner || opts.ByType || opts.ByDevice || opts.FindEmpty || opts.FindDupes ||
+		opts.Checksum || opts.Median || opts.LongPaths > 0 || opts.Visit != nil || len(opts.AgeBuckets) > 0 || len(opts.SizeBuckets) > 0
+}
+
+// progress calls the DirProgress, Progress and RootProgress functions that are set with the running totals of res.
+func (w *walker) progress(res *Result) {
+	if w.opts.DirProgress != nil {
+		w.opts.DirProgress(atomic.LoadInt64(&w.found), atomic.LoadInt64(&w.read))
+	}
+	if w.opts.Progress != nil {
+		w.opts.Progress(res.Files, res.Bytes)
+	}
+	if w.opts.RootProgress != nil {
+		totals := make([]Usage, len(res.Roots))
+		for i, root := range res.Roots {
+			totals[i] = *res.PerRoot[root]
+		}
+		w.opts.RootProgress(res.Roots, totals)
+	}
+}
+
+// rollUp adds the totals of the file or directory in r to those of its directory and of every parent
+// directory up to the root. Directories outside the MinDepth and MaxDepth limits are left out, the entries
+// of those deeper than MaxDepth only counting toward their kept parents.
+func (w *walker) rollUp(dirs map[string]*Usage, r result, add Usage) {
+	w.upward(r, func(dir string) {
+		u := dirs[dir]
+		if u == nil {
+			u = &Usage{}
+			dirs[dir] = u
+		}
+		u.Bytes += add.Bytes
+		u.Files += add.Files
+		u.Dirs += add.Dirs
+		u.Symlinks += add.Symlinks
+	})
+}
+
+// upward calls fn with the directory of r and every parent directory up to the root within the MinDepth and MaxDepth limits.
+func (w *walker) upward(r result, fn func(dir string)) {
+	for dir, depth := r.dir, r.depth; ; dir, depth = w.parent(dir), depth-1 {
+		if w.shown(depth) {
+			fn(dir)
+		}
+		if dir == r.root || dir == w.parent(dir) {
+			break
+		}
+	}
+}
+
+// shown reports whether directories at depth are within the MinDepth and MaxDepth limits.
+func (w *walker) shown(depth int) bool {
+	return depth >= w.opts.MinDepth && (w.opts.MaxDepth < 0 || depth <= w.opts.MaxDepth)
+}
+
+// lowerAll returns the lowercased patterns, to match lowercased names.
+func lowerAll(patterns []string) []string {
+	lower := make([]string, len(patterns))
+	for i, p := range patterns {
+		lower[i] = strings.ToLower(p)
+	}
+	return lower
+}
+
+// foldCase returns re matching case insensitively, or nil if re is nil.
+func foldCase(re *regexp.Regexp) *regexp.Regexp {
+	if re == nil {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + re.String())
+}
+
+// ext returns the lowercased extension of path, or NoExt if it has none.
+// Hidden files like .bashrc don't have an extension.
+func ext(path string) string {
+	name := filepath.Base(path)
+	e := filepath.Ext(name)
+	if e == "" || e == name {
+		return NoExt
+	}
+	return strings.ToLower(e)
+}
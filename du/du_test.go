@@ -0,0 +1,824 @@
+package du
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// file returns a map file of size bytes.
+func file(size int) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(strings.Repeat("x", size))}
+}
+
+// testTree is a small tree with known sizes: 3 directories holding 4 files of 100 bytes in all.
+func testTree() fstest.MapFS {
+	return fstest.MapFS{
+		"root/a.txt":     file(10),
+		"root/b.log":     file(20),
+		"root/sub/c.txt": file(30),
+		"root/sub/d.txt": file(40),
+		"root/empty":     &fstest.MapFile{Mode: fs.ModeDir},
+	}
+}
+
+func walk(t *testing.T, fsys fs.FS, opts Options, roots ...string) Result {
+	t.Helper()
+	opts.FS = fsys
+	res, err := Walk(roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestWalkTotals(t *testing.T) {
+	res := walk(t, testTree(), Options{PerDir: true, MaxDepth: -1}, "root")
+	if res.Files != 4 || res.Bytes != 100 || res.Directories != 3 {
+		t.Errorf("got %d files, %d bytes, %d directories, want 4, 100 and 3", res.Files, res.Bytes, res.Directories)
+	}
+	if !res.Apparent {
+		t.Errorf("sizes within an fs.FS are not reported as apparent")
+	}
+	want := map[string]Usage{
+		"root":       {Bytes: 100, Files: 4, Dirs: 3, Entries: 4},
+		"root/sub":   {Bytes: 70, Files: 2, Dirs: 1, Entries: 2},
+		"root/empty": {Dirs: 1},
+	}
+	if len(res.Dirs) != len(want) {
+		t.Errorf("got %d directories, want %d", len(res.Dirs), len(want))
+	}
+	for dir, u := range want {
+		if got := res.Dirs[dir]; got == nil || *got != u {
+			t.Errorf("%s: got %+v, want %+v", dir, got, u)
+		}
+	}
+	if u := res.PerRoot["root"]; u == nil || u.Bytes != 100 || u.Files != 4 || u.Dirs != 3 {
+		t.Errorf("root totals: got %+v", u)
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	res := walk(t, testTree(), Options{PerDir: true, MaxDepth: 0}, "root")
+	if len(res.Dirs) != 1 || res.Dirs["root"].Bytes != 100 {
+		t.Errorf("got %v, want only root with 100 bytes", res.Dirs)
+	}
+}
+
+func TestWalkMinDepth(t *testing.T) {
+	for _, opts := range []Options{{PerDir: true, MinDepth: 1, MaxDepth: -1}, {PerDir: true, MinDepth: 1, MaxDepth: -1, MaxFiles: 100}} {
+		res := walk(t, testTree(), opts, "root")
+		if len(res.Dirs) != 2 || res.Dirs["root/sub"].Bytes != 70 || res.Dirs["root/empty"] == nil || res.Bytes != 100 {
+			t.Errorf("max files %d: got %v and %d bytes, want only root/sub with 70 bytes and root/empty out of 100", opts.MaxFiles, res.Dirs, res.Bytes)
+		}
+	}
+}
+
+func TestDirMapAdd(t *testing.T) {
+	m := newDirMap()
+	m.add("root/sub", Usage{}, false)
+	if allocs := testing.AllocsPerRun(100, func() { m.add("root/sub", Usage{Bytes: 1}, false) }); allocs != 0 {
+		t.Errorf("got %v allocations per add, want 0", allocs)
+	}
+	for _, dir := range []string{"", "root", "root/sub", "/tmp/é/x"} {
+		h := fnv.New32a()
+		h.Write([]byte(dir))
+		if got, want := shardOf(dir), h.Sum32()%dirShards; got != want {
+			t.Errorf("shardOf(%q) = %d, want the FNV-1a shard %d", dir, got, want)
+		}
+	}
+}
+
+func TestWalkMaxDirs(t *testing.T) {
+	for _, opts := range []Options{{PerDir: true, MaxDepth: -1, MaxDirs: 1}, {PerDir: true, MaxDepth: -1, MaxDirs: 1, MaxFiles: 100}} {
+		res := walk(t, testTree(), opts, "root")
+		if len(res.Dirs) != 1 || res.Dirs["root"].Bytes != 100 || res.OtherDirs != 2 || res.OtherBytes != 70 {
+			t.Errorf("max files %d: got %v and %d other directories of %d bytes, want only root with 100 bytes and 2 of 70 bytes",
+				opts.MaxFiles, res.Dirs, res.OtherDirs, res.OtherBytes)
+		}
+	}
+}
+
+func TestWalkFilters(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		opts         Options
+		files, bytes int64
+	}{
+		{"exclude name", Options{Exclude: []string{"*.log"}}, 3, 80},
+		{"exclude directory", Options{Exclude: []string{"sub"}}, 2, 30},
+		{"exclude path", Options{Exclude: []string{"root/sub/c.txt"}}, 3, 70},
+		{"match", Options{Match: regexp.MustCompile(`\.txt$`)}, 3, 80},
+		{"nomatch", Options{NoMatch: regexp.MustCompile(`^[cd]`)}, 2, 30},
+		{"min size", Options{MinSize: 20}, 3, 90},
+		{"max size", Options{MaxSize: 30}, 3, 60},
+		{"count only", Options{CountOnly: true}, 4, 0},
+		{"include ext", Options{IncludeExts: []string{".TXT"}}, 3, 80},
+		{"exclude ext", Options{ExcludeExts: []string{"txt"}}, 1, 20},
+		{"exclude ext wins", Options{IncludeExts: []string{"txt", "log"}, ExcludeExts: []string{"log"}}, 3, 80},
+		{"only", Options{Only: []string{"**/sub/*.txt", "root/b.*"}}, 3, 90},
+		{"only any depth", Options{Only: []string{"**/*.txt"}}, 3, 80},
+		{"file filter", Options{FileFilter: func(name string, info fs.FileInfo) bool { return info.Size() > 20 || name == "a.txt" }}, 3, 80},
+		{"case sensitive", Options{Exclude: []string{"*.LOG"}, Only: []string{"**/SUB/*"}, Match: regexp.MustCompile(`^[A-Z]`)}, 0, 0},
+		{"ignore case", Options{Exclude: []string{"*.LOG", "ROOT/SUB/C.*"}, Only: []string{"**/SUB/*", "root/a.TXT"}, Match: regexp.MustCompile(`^[A-Z]`), IgnoreCase: true}, 2, 50},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := walk(t, testTree(), tt.opts, "root")
+			if res.Files != tt.files || res.Bytes != tt.bytes {
+				t.Errorf("got %d files and %d bytes, want %d and %d", res.Files, res.Bytes, tt.files, tt.bytes)
+			}
+		})
+	}
+}
+
+func TestWalkGitIgnore(t *testing.T) {
+	fsys := testTree()
+	fsys["root/.gitignore"] = &fstest.MapFile{Data: []byte("*.log\n")}
+	fsys["root/sub/.gitignore"] = &fstest.MapFile{Data: []byte("c.txt\n")}
+	res := walk(t, fsys, Options{GitIgnore: true, PerFile: true}, "root")
+	for _, path := range []string{"root/b.log", "root/sub/c.txt"} {
+		if _, ok := res.FileSizes[path]; ok {
+			t.Errorf("%s was not ignored", path)
+		}
+	}
+	if res.Files != 4 || res.Bytes != 10+40+6+6 {
+		t.Errorf("got %d files and %d bytes, want 4 and 62", res.Files, res.Bytes)
+	}
+}
+
+func TestWalkMultipleRoots(t *testing.T) {
+	res := walk(t, testTree(), Options{}, "root/sub", "root/a.txt/..")
+	if res.Roots[1] != "root" {
+		t.Errorf("got root %q, want it cleaned to root", res.Roots[1])
+	}
+	if res.PerRoot["root/sub"].Bytes != 70 || res.PerRoot["root"].Bytes != 100 {
+		t.Errorf("got per root totals %+v and %+v", res.PerRoot["root/sub"], res.PerRoot["root"])
+	}
+}
+
+func TestWalkDupesAndChecksum(t *testing.T) {
+	fsys := fstest.MapFS{
+		"one/a":     {Data: []byte("same")},
+		"one/b/c":   {Data: []byte("same")},
+		"one/d":     {Data: []byte("diff")},
+		"two/a":     {Data: []byte("same")},
+		"two/b/c":   {Data: []byte("same")},
+		"two/d":     {Data: []byte("diff")},
+		"three/a":   {Data: []byte("same")},
+		"three/b/c": {Data: []byte("same")},
+		"three/d":   {Data: []byte("other")},
+	}
+	one := walk(t, fsys, Options{FindDupes: true, Checksum: true}, "one")
+	if len(one.Dupes) != 1 || strings.Join(one.Dupes[0].Paths, " ") != "one/a one/b/c" || one.Dupes[0].Reclaimable() != 4 {
+		t.Errorf("got duplicates %+v, want one/a and one/b/c", one.Dupes)
+	}
+	two := walk(t, fsys, Options{Checksum: true}, "two")
+	three := walk(t, fsys, Options{Checksum: true}, "three")
+	if one.Checksum != two.Checksum {
+		t.Errorf("identical trees have different checksums")
+	}
+	if one.Checksum == three.Checksum {
+		t.Errorf("different trees have the same checksum")
+	}
+}
+
+func TestWalkLongPaths(t *testing.T) {
+	fsys := testTree()
+	fsys["root/sub/longer"] = &fstest.MapFile{Mode: fs.ModeDir}
+	res := walk(t, fsys, Options{LongPaths: 10}, "root")
+	if got := strings.Join(res.LongPaths, " "); got != "root/sub/longer root/sub/c.txt root/sub/d.txt" {
+		t.Errorf("got %s, want root/sub/longer root/sub/c.txt root/sub/d.txt", got)
+	}
+}
+
+func TestWalkFindEmpty(t *testing.T) {
+	fsys := testTree()
+	fsys["root/zero"] = &fstest.MapFile{}
+	res := walk(t, fsys, Options{FindEmpty: true}, "root")
+	if fmt.Sprint(res.EmptyDirs, res.EmptyFiles) != "[root/empty] [root/zero]" {
+		t.Errorf("got empty directories %v and files %v", res.EmptyDirs, res.EmptyFiles)
+	}
+}
+
+// wideTree returns a tree of n directories holding a file of 1 byte each, spread over two levels.
+func wideTree(n int) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i := 0; i < n; i++ {
+		fsys[fmt.Sprintf("root/%d/%d/f", i%10, i)] = file(1)
+	}
+	return fsys
+}
+
+// TestWalkWide walks more directories than the queue holds with few workers, so that some of them are
+// walked inline, and checks that every directory completes once, after all of its subdirectories.
+func TestWalkWide(t *testing.T) {
+	const n = 5000
+	var mu sync.Mutex
+	done := make(map[string]Usage)
+	opts := Options{Threads: 2, MaxOpen: 1, PerDir: true, MaxDepth: -1, DirDone: func(path string, u Usage) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := done[path]; ok {
+			t.Errorf("%s completed twice", path)
+		}
+		if parent := filepath.Dir(path); parent != "." {
+			if _, ok := done[parent]; ok {
+				t.Errorf("%s completed after its parent", path)
+			}
+		}
+		done[path] = u
+	}}
+	res := walk(t, wideTree(n), opts, "root")
+	if res.Files != n || res.Bytes != n || res.Directories != 1+10+n {
+		t.Errorf("got %d files, %d bytes and %d directories", res.Files, res.Bytes, res.Directories)
+	}
+	if len(done) != len(res.Dirs) {
+		t.Errorf("got %d completed directories, want %d", len(done), len(res.Dirs))
+	}
+	for dir, u := range res.Dirs {
+		if done[dir] != *u {
+			t.Errorf("%s: completed with %+v, want %+v", dir, done[dir], *u)
+		}
+	}
+}
+
+func TestWalkCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := WalkContext(ctx, []string{"root"}, Options{FS: wideTree(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Partial {
+		t.Errorf("cancelled walk is not partial")
+	}
+}
+
+// errFS fails to open the directories named in fail.
+type errFS struct {
+	fs.FS
+	fail map[string]bool
+}
+
+var errDenied = errors.New("denied")
+
+func (f errFS) Open(name string) (fs.File, error) {
+	if f.fail[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errDenied}
+	}
+	return f.FS.Open(name)
+}
+
+func TestWalkErrors(t *testing.T) {
+	res := walk(t, errFS{testTree(), map[string]bool{"root/sub": true}}, Options{}, "root")
+	if len(res.Errors) != 1 || res.Errors[0].Path != "root/sub" || !errors.Is(res.Errors[0].Err, errDenied) {
+		t.Fatalf("got errors %v, want root/sub denied", res.Errors)
+	}
+	if res.Files != 2 || res.Bytes != 30 {
+		t.Errorf("got %d files and %d bytes, want 2 and 30", res.Files, res.Bytes)
+	}
+}
+
+func TestWalkOptionErrors(t *testing.T) {
+	for _, opts := range []Options{
+		{Exclude: []string{"["}},
+		{MinSize: 10, MaxSize: 5},
+		{SizeBuckets: []int64{10, 5}},
+		{FS: testTree(), FollowLinks: true},
+		{NewerThan: time.Unix(2, 0), OlderThan: time.Unix(1, 0)},
+		{CountOnly: true, NewerThan: time.Unix(1, 0)},
+		{CountOnly: true, UIDs: []uint32{0}},
+		{CountOnly: true, MinSize: 1},
+		{CountOnly: true, MaxSize: 10},
+		{CountOnly: true, FileFilter: func(string, fs.FileInfo) bool { return true }},
+		{CountOnly: true, SizeBuckets: []int64{10}},
+	} {
+		if _, err := Walk([]string{"root"}, opts); err == nil {
+			t.Errorf("%+v: got no error", opts)
+		}
+	}
+}
+
+// TestWalkOS checks that walking a directory of the operating system's file system gives the same apparent
+// totals as walking it through os.DirFS.
+func TestWalkOS(t *testing.T) {
+	dir := t.TempDir()
+	for path, f := range testTree() {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if f.Mode.IsDir() {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, f.Data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	native, err := Walk([]string{filepath.Join(dir, "root")}, Options{Apparent: true, Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirFS := walk(t, os.DirFS(dir), Options{Checksum: true}, "root")
+	if native.Files != 4 || native.Bytes != 100 || native.Directories != 3 {
+		t.Errorf("got %d files, %d bytes and %d directories", native.Files, native.Bytes, native.Directories)
+	}
+	if native.Files != dirFS.Files || native.Bytes != dirFS.Bytes || native.Directories != dirFS.Directories || native.Checksum != dirFS.Checksum {
+		t.Errorf("got %+v through os.DirFS, want %+v", dirFS, native)
+	}
+}
+
+func TestWalkMaxFiles(t *testing.T) {
+	res := walk(t, testTree(), Options{MaxFiles: 2}, "root")
+	if res.Files != 2 || !res.LimitReached || !res.Partial {
+		t.Errorf("got %d files, limit reached %v and partial %v, want 2 files past the limit", res.Files, res.LimitReached, res.Partial)
+	}
+	res = walk(t, testTree(), Options{MaxFiles: 4}, "root")
+	if res.Files != 4 || res.LimitReached || res.Partial {
+		t.Errorf("got %d files, limit reached %v and partial %v, want all 4 files", res.Files, res.LimitReached, res.Partial)
+	}
+}
+
+func TestWalkBlockSize(t *testing.T) {
+	fsys := testTree()
+	fsys["root/zero"] = &fstest.MapFile{}
+	res := walk(t, fsys, Options{BlockSize: 16, PerFile: true}, "root")
+	if res.Bytes != 16+32+32+48 || res.FileSizes["root/zero"] != 0 {
+		t.Errorf("got %d bytes with %d for the empty file, want 128 and 0", res.Bytes, res.FileSizes["root/zero"])
+	}
+}
+
+func TestWalkVisit(t *testing.T) {
+	var events []string
+	opts := Options{Visit: func(ev FileEvent) {
+		events = append(events, fmt.Sprintf("%s %d %v %v", ev.Path, ev.Size, ev.IsDir, ev.Err != nil))
+		if !ev.IsDir {
+			events[len(events)-1] += fmt.Sprintf(" %v %d", ev.Mode, ev.ModTime.Year())
+		}
+	}}
+	fsys := testTree()
+	fsys["root/a.txt"].Mode = 0o640
+	fsys["root/a.txt"].ModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	walk(t, errFS{fsys, map[string]bool{"root/sub": true}}, opts, "root")
+	sort.Strings(events)
+	want := []string{
+		"root 30 true false",
+		"root/a.txt 10 false false -rw-r----- 2024",
+		"root/b.log 20 false false ---------- 1",
+		"root/empty 0 true false",
+		"root/sub 0 true false",
+		"root/sub 0 true true",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWalkModTime(t *testing.T) {
+	day := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"root/before": {Data: []byte("1"), ModTime: day.Add(-time.Second)},
+		"root/start":  {Data: []byte("22"), ModTime: day},
+		"root/within": {Data: []byte("333"), ModTime: day.Add(12 * time.Hour)},
+		"root/end":    {Data: []byte("4444"), ModTime: day.Add(24 * time.Hour)},
+	}
+	for _, tt := range []struct {
+		name         string
+		opts         Options
+		files, bytes int64
+	}{
+		{"newer", Options{NewerThan: day}, 3, 9},
+		{"older", Options{OlderThan: day.Add(24 * time.Hour)}, 3, 6},
+		{"window", Options{NewerThan: day, OlderThan: day.Add(24 * time.Hour)}, 2, 5},
+		{"other time zone", Options{NewerThan: day.In(time.FixedZone("UTC-8", -8*3600))}, 3, 9},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := walk(t, fsys, tt.opts, "root")
+			if res.Files != tt.files || res.Bytes != tt.bytes {
+				t.Errorf("got %d files and %d bytes, want %d and %d", res.Files, res.Bytes, tt.files, tt.bytes)
+			}
+		})
+	}
+}
+
+func TestWalkByDepth(t *testing.T) {
+	res := walk(t, testTree(), Options{ByDepth: true}, "root")
+	want := []Usage{{Bytes: 30, Files: 2, Dirs: 1}, {Bytes: 70, Files: 2, Dirs: 2}}
+	if fmt.Sprint(res.Depths) != fmt.Sprint(want) {
+		t.Errorf("got depths %v, want %v", res.Depths, want)
+	}
+}
+
+func TestWalkTopDirs(t *testing.T) {
+	res := walk(t, testTree(), Options{TopDirs: 5}, "root")
+	want := []File{{Path: "root/sub", Size: 70}, {Path: "root/empty"}}
+	if fmt.Sprint(res.TopDirs) != fmt.Sprint(want) {
+		t.Errorf("got largest directories %v, want %v", res.TopDirs, want)
+	}
+}
+
+func TestWalkByType(t *testing.T) {
+	fsys := testTree()
+	fsys["root/pipe"] = &fstest.MapFile{Mode: fs.ModeNamedPipe, Data: []byte("ignored")}
+	fsys["root/link"] = &fstest.MapFile{Mode: fs.ModeSymlink, Data: []byte("a.txt")}
+	fsys["root/dangling"] = &fstest.MapFile{Mode: fs.ModeSymlink, Data: []byte("missing")}
+	res := walk(t, fsys, Options{ByType: true}, "root")
+	want := map[string]Usage{
+		TypeRegular:  {Bytes: 100, Files: 4},
+		TypeDir:      {Dirs: 3},
+		TypePipe:     {Files: 1},
+		TypeSymlink:  {Bytes: 5, Files: 1},
+		TypeDangling: {Bytes: 7, Files: 1},
+	}
+	if len(res.Types) != len(want) {
+		t.Errorf("got %d types, want %d", len(res.Types), len(want))
+	}
+	for typ, u := range want {
+		if got := res.Types[typ]; got == nil || *got != u {
+			t.Errorf("%s: got %+v, want %+v", typ, got, u)
+		}
+	}
+	if res.Bytes != 112 {
+		t.Errorf("got %d bytes, want 112 without the pipe", res.Bytes)
+	}
+}
+
+// archives returns a tar and a zip archive both holding the files of testTree.
+func archives(t *testing.T) (tarData, zipData []byte) {
+	var tb, zb bytes.Buffer
+	tw, zw := tar.NewWriter(&tb), zip.NewWriter(&zb)
+	for _, name := range []string{"a.txt", "b.log", "sub/c.txt", "sub/d.txt"} {
+		data := testTree()["root/"+name].Data
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(data)), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+		zf, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zf.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tb.Bytes(), zb.Bytes()
+}
+
+func TestWalkArchives(t *testing.T) {
+	tarData, zipData := archives(t)
+	fsys := fstest.MapFS{
+		"root/t.tar":   {Data: tarData},
+		"root/t.zip":   {Data: zipData},
+		"root/bad.zip": {Data: []byte("not a zip")},
+	}
+	var done []string
+	opts := Options{Archives: true, PerDir: true, MaxDepth: -1, DirDone: func(path string, u Usage) {
+		done = append(done, path)
+	}}
+	res := walk(t, fsys, opts, "root")
+	if res.Files != 9 || res.Bytes != 209 || res.Directories != 5 {
+		t.Errorf("got %d files, %d bytes and %d directories, want 9, 209 and 5", res.Files, res.Bytes, res.Directories)
+	}
+	for _, dir := range []string{"root/t.tar", "root/t.zip"} {
+		if u := res.Dirs[dir]; u == nil || *u != (Usage{Bytes: 100, Files: 4, Dirs: 2, Entries: 3}) {
+			t.Errorf("%s: got %+v", dir, u)
+		}
+		if u := res.Dirs[dir+"/sub"]; u == nil || *u != (Usage{Bytes: 70, Files: 2, Dirs: 1, Entries: 2}) {
+			t.Errorf("%s/sub: got %+v", dir, u)
+		}
+	}
+	if len(done) != 5 || done[len(done)-1] != "root" {
+		t.Errorf("got completed directories %v, want 5 ending with root", done)
+	}
+}
+
+func TestWalkMaxRate(t *testing.T) {
+	start := time.Now()
+	res := walk(t, testTree(), Options{MaxRate: 20}, "root")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("walked 3 directories at 20 per second in %v", elapsed)
+	}
+	if res.Files != 4 {
+		t.Errorf("got %d files, want 4", res.Files)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	res, err := WalkContext(ctx, []string{"root"}, Options{FS: wideTree(100), MaxRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); !res.Partial || elapsed > time.Second {
+		t.Errorf("got partial %v after %v, want the throttled walk to stop on cancellation", res.Partial, elapsed)
+	}
+}
+
+func TestWalkLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	walk(t, testTree(), Options{Logger: logger, SlowRead: time.Nanosecond, Exclude: []string{"*.log"}}, "root")
+	for _, want := range []string{
+		`level=DEBUG msg="entering directory" path=root/sub depth=1`,
+		`level=WARN msg="read directory" path=root/sub entries=2`,
+		`level=DEBUG msg="skipping entry" path=root/b.log reason=excluded`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWalkCache(t *testing.T) {
+	fsys := testTree()
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"root", "root/sub", "root/empty"} {
+		fsys[dir] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: mtime}
+	}
+	cache := NewCache()
+	walk(t, fsys, Options{Cache: cache}, "root")
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := LoadCache(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Growing a file in place doesn't change the modification time of its directory, whose cached totals are reused
+	fsys["root/sub/c.txt"] = file(130)
+	res := walk(t, fsys, Options{Cache: cache, PerDir: true, MaxDepth: -1}, "root")
+	if res.Files != 4 || res.Bytes != 100 || res.Directories != 3 || res.Dirs["root"].Bytes != 100 || res.Dirs["root/sub"].Files != 2 {
+		t.Errorf("got %d files, %d bytes, %d directories and %+v, want the cached totals", res.Files, res.Bytes, res.Directories, res.Dirs["root"])
+	}
+	fsys["root/sub"].ModTime = mtime.Add(time.Second)
+	if res = walk(t, fsys, Options{Cache: cache}, "root"); res.Files != 4 || res.Bytes != 200 {
+		t.Errorf("got %d files and %d bytes after the directory changed, want 4 and 200", res.Files, res.Bytes)
+	}
+	fsys["root/a.txt"] = file(110)
+	if res = walk(t, fsys, Options{Cache: cache, MinSize: 1}, "root"); res.Bytes != 300 {
+		t.Errorf("got %d bytes with other options, want 300 counted afresh", res.Bytes)
+	}
+	if _, err := Walk([]string{"root"}, Options{FS: fsys, Cache: cache, Top: 1}); err == nil {
+		t.Error("walked with the cache and Top, want an error")
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	info := "28 1 254:0 / / rw,relatime shared:1 - ext4 /dev/vda rw\n" +
+		"40 28 0:45 / /mnt/my\\040share rw - nfs4 server:/export rw,vers=4.2\n"
+	mounts, err := parseMountInfo(strings.NewReader(info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mount{{Dev: 0xfe00, Path: "/", Type: "ext4", Source: "/dev/vda"}, {Dev: 45, Path: "/mnt/my share", Type: "nfs4", Source: "server:/export"}}
+	if fmt.Sprint(mounts) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", mounts, want)
+	}
+	if _, err := parseMountInfo(strings.NewReader("28 1 254:0 / /\n")); err == nil {
+		t.Error("parsed a truncated line, want an error")
+	}
+}
+
+func TestWalkPerm(t *testing.T) {
+	fsys := testTree()
+	fsys["root/a.txt"].Mode = 0o666
+	fsys["root/sub/c.txt"].Mode = fs.ModeSetuid | 0o755
+	for i, tt := range []struct {
+		opts  Options
+		files int64
+		bytes int64
+	}{
+		{Options{PermAll: 0o002}, 1, 10},
+		{Options{PermAny: 0o002 | fs.ModeSetuid}, 2, 40},
+		{Options{PermAll: 0o002 | fs.ModeSetuid}, 0, 0},
+		{Options{UIDs: []uint32{0}}, 0, 0}, // map files have no owners
+	} {
+		if res := walk(t, fsys, tt.opts, "root"); res.Files != tt.files || res.Bytes != tt.bytes {
+			t.Errorf("%d: got %d files and %d bytes, want %d and %d", i, res.Files, res.Bytes, tt.files, tt.bytes)
+		}
+	}
+	if _, err := Walk([]string{"root"}, Options{FS: fsys, PermAll: fs.ModeDir}); err == nil {
+		t.Error("walked with a directory bit in PermAll, want an error")
+	}
+}
+
+func TestWalkBatch(t *testing.T) {
+	// MaxFiles needs a result for each file, unlike the other options sending the totals of each directory at once
+	batched := walk(t, testTree(), Options{PerDir: true, MaxDepth: -1, ByDepth: true}, "root")
+	perFile := walk(t, testTree(), Options{PerDir: true, MaxDepth: -1, ByDepth: true, MaxFiles: 100}, "root")
+	if batched.Files != perFile.Files || batched.Bytes != perFile.Bytes || *batched.PerRoot["root"] != *perFile.PerRoot["root"] {
+		t.Errorf("got %d files and %d bytes batched, want %d and %d", batched.Files, batched.Bytes, perFile.Files, perFile.Bytes)
+	}
+	for dir, u := range perFile.Dirs {
+		if batched.Dirs[dir] == nil || *batched.Dirs[dir] != *u {
+			t.Errorf("%s: got %+v batched, want %+v", dir, batched.Dirs[dir], *u)
+		}
+	}
+	if fmt.Sprint(batched.Depths) != fmt.Sprint(perFile.Depths) {
+		t.Errorf("got depths %v batched, want %v", batched.Depths, perFile.Depths)
+	}
+}
+
+func TestWalkExcludeHidden(t *testing.T) {
+	fsys := fstest.MapFS{
+		".root/a.txt":        file(10),
+		".root/.profile":     file(20),
+		".root/.git/config":  file(30),
+		".root/sub/.b.swp":   file(40),
+		".root/sub/c.txt":    file(50),
+		".root/sub/.d/e.txt": file(60),
+	}
+	res := walk(t, fsys, Options{ExcludeHidden: true}, ".root")
+	if res.Files != 2 || res.Bytes != 60 || res.Directories != 2 {
+		t.Errorf("got %d files, %d bytes and %d directories, want 2, 60 and 2", res.Files, res.Bytes, res.Directories)
+	}
+}
+
+// flakyFS fails to open the directories of fail with EIO the given number of times.
+type flakyFS struct {
+	fs.FS
+	mu   sync.Mutex
+	fail map[string]int
+}
+
+func (f *flakyFS) Open(name string) (fs.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail[name] > 0 {
+		f.fail[name]--
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EIO}
+	}
+	return f.FS.Open(name)
+}
+
+func TestWalkRetries(t *testing.T) {
+	var notes []string
+	fsys := &flakyFS{FS: testTree(), fail: map[string]int{"root/sub": 2}}
+	res := walk(t, fsys, Options{RetryDelay: time.Millisecond, Logf: func(format string, args ...interface{}) {
+		notes = append(notes, fmt.Sprintf(format, args...))
+	}}, "root")
+	if len(res.Errors) != 0 || res.Files != 4 || len(notes) != 2 {
+		t.Errorf("got %d files, errors %v and notes %q, want 4 files counted after 2 retries", res.Files, res.Errors, notes)
+	}
+	fsys = &flakyFS{FS: testTree(), fail: map[string]int{"root/sub": 2}}
+	res = walk(t, fsys, Options{Retries: 1, RetryDelay: time.Millisecond}, "root")
+	if len(res.Errors) != 1 || !errors.Is(res.Errors[0].Err, syscall.EIO) || res.Files != 2 {
+		t.Errorf("got %d files and errors %v, want 2 files and root/sub failing after 1 retry", res.Files, res.Errors)
+	}
+	res = walk(t, errFS{testTree(), map[string]bool{"root/sub": true}}, Options{RetryDelay: time.Hour}, "root")
+	if len(res.Errors) != 1 {
+		t.Errorf("got errors %v, want root/sub denied without retrying", res.Errors)
+	}
+}
+
+func TestMedianSize(t *testing.T) {
+	res := walk(t, testTree(), Options{Median: true}, "root")
+	if res.MedianSize != 20 {
+		t.Errorf("got median %d, want 20", res.MedianSize)
+	}
+	var h sizeHistogram
+	for size := int64(1); size <= 1e6+1; size++ {
+		h.add(size * 1000)
+	}
+	want := int64(500001 * 1000)
+	if got := h.median(); got < want*97/100 || got > want*103/100 {
+		t.Errorf("got median %d, want within 3%% of %d", got, want)
+	}
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	fsys := testTree()
+	fsys["root/link"] = &fstest.MapFile{Data: []byte("a.txt"), Mode: fs.ModeSymlink}
+	fsys["root/sub/link"] = &fstest.MapFile{Data: []byte("c.txt"), Mode: fs.ModeSymlink}
+	for _, opts := range []Options{{PerDir: true, MaxDepth: -1}, {PerDir: true, MaxDepth: -1, Top: 1}} {
+		done := make(map[string]int64)
+		opts.DirDone = func(dir string, u Usage) { done[dir] = u.Symlinks }
+		res := walk(t, fsys, opts, "root")
+		if res.Dirs["root"].Symlinks != 2 || res.Dirs["root/sub"].Symlinks != 1 || res.PerRoot["root"].Symlinks != 2 {
+			t.Errorf("top %d: got %d, %d and %d symlinks, want 2 in root and 1 in root/sub", opts.Top, res.Dirs["root"].Symlinks, res.Dirs["root/sub"].Symlinks, res.PerRoot["root"].Symlinks)
+		}
+		if done["root"] != 2 || done["root/sub"] != 1 {
+			t.Errorf("top %d: got %v symlinks once done, want 2 in root and 1 in root/sub", opts.Top, done)
+		}
+	}
+}
+
+// TestWalkDirMap checks that the totals of the directories rolled up by many workers at once, run with -race,
+// are those rolled up by the collector when MaxFiles needs a result for each file.
+func TestWalkDirMap(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			for k := 0; k < 5; k++ {
+				fsys[fmt.Sprintf("root/%d/%d/%d", i, j, k)] = file(i + j + k)
+			}
+		}
+	}
+	opts := Options{PerDir: true, MaxDepth: -1, Threads: 16}
+	workers := walk(t, fsys, opts, "root")
+	opts.MaxFiles = 1e6
+	collector := walk(t, fsys, opts, "root")
+	if len(workers.Dirs) != 221 || len(workers.Dirs) != len(collector.Dirs) {
+		t.Fatalf("got %d directories, want 221 like the %d of the collector", len(workers.Dirs), len(collector.Dirs))
+	}
+	for dir, u := range collector.Dirs {
+		if *workers.Dirs[dir] != *u {
+			t.Errorf("%s: got %+v, want %+v", dir, *workers.Dirs[dir], *u)
+		}
+	}
+}
+
+// TestWalkResume checks that the subtrees checkpointed by a walk are counted from their totals by the next one,
+// even once they changed, and give the same totals as the walk with them.
+func TestWalkResume(t *testing.T) {
+	fsys := testTree()
+	completed := make(map[string]Usage)
+	want := walk(t, fsys, Options{PerDir: true, MaxDepth: -1, Checkpoint: func(dir string, u Usage) { completed[dir] = u }}, "root")
+	if len(completed) != 2 || completed["root/sub"] != (Usage{Bytes: 70, Files: 2, Dirs: 1, Entries: 2}) {
+		t.Fatalf("got checkpoints %v, want root/sub and root/empty", completed)
+	}
+	fsys["root/sub/e.txt"] = file(1000)
+	fsys["root/sub/deeper/f.txt"] = file(1000)
+	for _, opts := range []Options{{PerDir: true, MaxDepth: -1}, {PerDir: true, MaxDepth: -1, Top: 1}} {
+		opts.Completed = completed
+		res := walk(t, fsys, opts, "root")
+		if res.Bytes != want.Bytes || res.Files != want.Files || res.Directories != want.Directories {
+			t.Errorf("top %d: got %d bytes in %d files and %d directories, want %d in %d and %d",
+				opts.Top, res.Bytes, res.Files, res.Directories, want.Bytes, want.Files, want.Directories)
+		}
+		if *res.Dirs["root"] != *want.Dirs["root"] || *res.Dirs["root/sub"] != *want.Dirs["root/sub"] {
+			t.Errorf("top %d: got %+v and %+v, want %+v and %+v", opts.Top, *res.Dirs["root"], *res.Dirs["root/sub"], *want.Dirs["root"], *want.Dirs["root/sub"])
+		}
+	}
+}
+
+// slowFS takes delay to open each directory, to read it, like a network file system.
+type slowFS struct {
+	fs.FS
+	delay time.Duration
+}
+
+func (f slowFS) Open(name string) (fs.File, error) {
+	if info, err := fs.Stat(f.FS, name); err == nil && info.IsDir() {
+		time.Sleep(f.delay)
+	}
+	return f.FS.Open(name)
+}
+
+// TestWalkAutoTune checks that AutoTune reads more directories at once while that speeds up a slow file system.
+func TestWalkAutoTune(t *testing.T) {
+	res := walk(t, slowFS{wideTree(300), 10 * time.Millisecond}, Options{AutoTune: true, Threads: 16}, "root")
+	if res.Files != 300 || res.Directories != 311 {
+		t.Errorf("got %d files and %d directories, want 300 and 311", res.Files, res.Directories)
+	}
+	if res.TunedReads <= 2 || res.TunedReads > 16 {
+		t.Errorf("got %d directories read at once, want more than 2 and at most 16", res.TunedReads)
+	}
+}
+
+// TestWalkSample checks that the sizes estimated from a sample of files of the same size are exact,
+// and that the totals of a tree whose files are all sampled are.
+func TestWalkSample(t *testing.T) {
+	fsys := wideTree(1000)
+	for i := 0; i < 1000; i++ {
+		fsys[fmt.Sprintf("root/%d/%d/f", i%10, i)] = file(100)
+	}
+	res := walk(t, fsys, Options{Sample: 0.1}, "root")
+	if res.Files != 1000 || res.Bytes != 100000 || res.PerRoot["root"].Bytes != 100000 || res.SampleMargin != 0 {
+		t.Errorf("got %d files and %d bytes ± %d, want 1000 and 100000 ± 0", res.Files, res.Bytes, res.SampleMargin)
+	}
+	if res.SampledFiles < 20 || res.SampledFiles > 300 {
+		t.Errorf("got %d sampled files, want about 100", res.SampledFiles)
+	}
+	res = walk(t, testTree(), Options{Sample: 1, MaxFiles: 10}, "root")
+	if res.Files != 4 || res.Bytes != 100 || res.SampledFiles != 4 || res.SampleMargin != 0 {
+		t.Errorf("got %d files and %d bytes ± %d from %d sampled files, want 4 and 100 ± 0 from 4", res.Files, res.Bytes, res.SampleMargin, res.SampledFiles)
+	}
+	if _, err := Walk([]string{"root"}, Options{FS: testTree(), Sample: 0.5, PerDir: true}); err == nil {
+		t.Error("got no error sampling the totals of the directories")
+	}
+}
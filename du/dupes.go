@@ -0,0 +1,102 @@
+package du
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// DupeGroup is a set of files with identical contents.
+type DupeGroup struct {
+	Size  int64    // apparent size of each file
+	Paths []string // sorted paths of the files
+}
+
+// Reclaimable returns the number of bytes freed by keeping a single file of the group.
+func (g DupeGroup) Reclaimable() int64 {
+	return g.Size * int64(len(g.Paths)-1)
+}
+
+// findDupes returns the groups of files with identical contents among the files of the same size
+// in bySize, largest reclaimable space first. Only the files sharing their size with another file are
+// read, by Threads workers at once. Files of size 0 are left out.
+func (w *walker) findDupes(ctx context.Context, bySize map[int64][]string) []DupeGroup {
+	type hashed struct {
+		size int64
+		sum  [sha256.Size]byte
+	}
+	var mu sync.Mutex
+	groups := make(map[hashed][]string)
+
+	paths := make(chan string)
+	sizes := make(map[string]int64)
+	for size, candidates := range bySize {
+		if size > 0 && len(candidates) > 1 {
+			for _, path := range candidates {
+				sizes[path] = size
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < w.opts.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if !w.limit.wait(ctx) {
+					continue
+				}
+				sum, err := hashFile(w.fsys, path)
+				if err != nil {
+					w.errs.add(path, err)
+					continue
+				}
+				key := hashed{size: sizes[path], sum: sum}
+				mu.Lock()
+				groups[key] = append(groups[key], path)
+				mu.Unlock()
+			}
+		}()
+	}
+	for path := range sizes {
+		if ctx.Err() != nil {
+			break
+		}
+		paths <- path
+	}
+	close(paths)
+	wg.Wait()
+
+	var dupes []DupeGroup
+	for key, paths := range groups {
+		if len(paths) > 1 {
+			sort.Strings(paths)
+			dupes = append(dupes, DupeGroup{Size: key.size, Paths: paths})
+		}
+	}
+	sort.Slice(dupes, func(i, j int) bool {
+		if dupes[i].Reclaimable() != dupes[j].Reclaimable() {
+			return dupes[i].Reclaimable() > dupes[j].Reclaimable()
+		}
+		return dupes[i].Paths[0] < dupes[j].Paths[0]
+	})
+	return dupes
+}
+
+// hashFile returns the SHA-256 digest of the contents of the file at path in fsys.
+func hashFile(fsys fs.FS, path string) (sum [sha256.Size]byte, err error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
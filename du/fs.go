@@ -0,0 +1,64 @@
+package du
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// osFS is the file system walked when Options.FS isn't set, the operating system's, opening paths as they are
+// rather than within a root directory like os.DirFS.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) ReadLink(name string) (string, error)       { return os.Readlink(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return readDir(name) }
+
+// readLinkFS is a file system reporting the targets of its symbolic links.
+type readLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+}
+
+// readDir returns the entries of directory dir in directory order. Unlike os.ReadDir it doesn't sort them.
+func readDir(dir string) ([]os.DirEntry, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.ReadDir(-1)
+}
+
+// native reports whether the walk is on the operating system's file system, with its paths.
+func (w *walker) native() bool {
+	_, ok := w.fsys.(osFS)
+	return ok
+}
+
+// join joins the name of an entry to the path of its directory.
+func (w *walker) join(dir, name string) string {
+	if w.native() {
+		return filepath.Join(dir, name)
+	}
+	return path.Join(dir, name)
+}
+
+// parent returns the directory of p.
+func (w *walker) parent(p string) string {
+	if w.native() {
+		return filepath.Dir(p)
+	}
+	return path.Dir(p)
+}
+
+// clean returns the shortest path equivalent to p.
+func (w *walker) clean(p string) string {
+	if w.native() {
+		return filepath.Clean(p)
+	}
+	return path.Clean(p)
+}
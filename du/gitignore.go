@@ -0,0 +1,156 @@
+package du
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp // matches the slash separated path relative to the directory of the .gitignore file
+	negate  bool           // set for the !pattern rules re-including what previous rules ignored
+	dirOnly bool           // set for the pattern/ rules only matching directories
+}
+
+// ignoreList holds the rules of the .gitignore file of a directory, on top of those of its parent directories.
+type ignoreList struct {
+	dir    string
+	rules  []ignoreRule
+	parent *ignoreList
+}
+
+// ignored reports whether the entry at path is ignored by the rules of l. The last matching rule of the
+// deepest .gitignore file wins, like git does.
+func (l *ignoreList) ignored(path string, isDir bool) bool {
+	for ; l != nil; l = l.parent {
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for i := len(l.rules) - 1; i >= 0; i-- {
+			r := l.rules[i]
+			if (!r.dirOnly || isDir) && r.re.MatchString(rel) {
+				return !r.negate
+			}
+		}
+	}
+	return false
+}
+
+// parseIgnore returns the rules of the .gitignore file of dir holding data, on top of parent.
+func parseIgnore(dir string, data []byte, parent *ignoreList) *ignoreList {
+	l := &ignoreList{dir: dir, parent: parent}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if r, ok := parseIgnoreRule(scanner.Text()); ok {
+			l.rules = append(l.rules, r)
+		}
+	}
+	if len(l.rules) == 0 {
+		return parent
+	}
+	return l
+}
+
+// parseIgnoreRule returns the rule of a line of a .gitignore file, ok is false for blank lines and comments.
+func parseIgnoreRule(line string) (r ignoreRule, ok bool) {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return r, false
+	}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return r, false
+	}
+	// Patterns with a slash at the beginning or in the middle are relative to the directory of the .gitignore
+	// file, the others match at any level below it
+	prefix := "^"
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		prefix = "^(?:.*/)?"
+	}
+	re, err := regexp.Compile(prefix + globRegexp(line) + "$")
+	if err != nil {
+		return r, false
+	}
+	r.re = re
+	return r, true
+}
+
+// globRegexp translates a .gitignore glob to a regular expression, ** matching any number of directories.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				switch {
+				case strings.HasPrefix(glob[i:], "**/"):
+					b.WriteString("(?:.*/)?")
+					i += 2
+				default:
+					b.WriteString(".*")
+					i++
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				break
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// compileGlobs returns the regular expressions matching whole paths translated from globs by globRegexp,
+// case insensitively if ignoreCase is set.
+func compileGlobs(globs []string, ignoreCase bool) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	flags := ""
+	if ignoreCase {
+		flags = "(?i)"
+	}
+	for _, glob := range globs {
+		re, err := regexp.Compile(flags + "^" + globRegexp(filepath.ToSlash(glob)) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("glob %q: %v", glob, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
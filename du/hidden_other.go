@@ -0,0 +1,11 @@
+//go:build !windows
+
+package du
+
+import "os"
+
+// hiddenAttr always reports false as only Windows has a hidden attribute, other platforms
+// hiding the files whose name starts with a dot.
+func hiddenAttr(entry os.DirEntry) bool {
+	return false
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package du
+
+import (
+	"os"
+	"syscall"
+)
+
+// hiddenAttr reports whether entry has the hidden attribute.
+func hiddenAttr(entry os.DirEntry) bool {
+	info, err := entry.Info()
+	if err != nil {
+		return false
+	}
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && attrs.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
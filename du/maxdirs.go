@@ -0,0 +1,40 @@
+package du
+
+// boundedDirs keeps the totals of the Options.MaxDirs largest directory subtrees completely walked so far,
+// counting the other directories and the sizes of their own files instead, so that the memory used by
+// PerDir stays bounded. Only the subtrees still in progress are tracked besides them.
+type boundedDirs struct {
+	max      int
+	top      topFiles          // subtree sizes of the directories kept
+	dirs     map[string]*Usage // totals of the directories kept
+	own      map[string]int64  // sizes of the own files of the directories kept
+	children map[string]int64  // total size of the completed subdirectories of each directory in progress
+	others   int64             // number of directories left out
+	bytes    int64             // total size of the own files of the directories left out
+}
+
+func newBoundedDirs(max int) *boundedDirs {
+	return &boundedDirs{max: max, dirs: make(map[string]*Usage), own: make(map[string]int64), children: make(map[string]int64)}
+}
+
+// done records the totals of the directory subtree of r, a done result, leaving out the smallest directory
+// kept so far, or this one, once more than max directories are kept. Directories outside the MinDepth and
+// MaxDepth limits aren't kept, those deeper than MaxDepth counting as the own files of their kept parents.
+func (b *boundedDirs) done(w *walker, r result) {
+	if !w.shown(r.depth) {
+		return
+	}
+	own := r.size - b.children[r.dir]
+	delete(b.children, r.dir)
+	if r.depth > 0 && w.shown(r.depth-1) {
+		b.children[w.parent(r.dir)] += r.size
+	}
+	b.dirs[r.dir] = &Usage{Bytes: r.size, Files: r.files, Dirs: r.dirs, Entries: r.entries, Symlinks: r.symlinks}
+	b.own[r.dir] = own
+	if dropped, ok := b.top.offer(File{Path: r.dir, Size: r.size}, b.max); ok {
+		b.others++
+		b.bytes += b.own[dropped.Path]
+		delete(b.dirs, dropped.Path)
+		delete(b.own, dropped.Path)
+	}
+}
@@ -0,0 +1,56 @@
+package du
+
+import "math/bits"
+
+// medianBits is the number of significant bits of the sizes kept by sizeHistogram, the sizes of a bucket
+// differing by less than 1/2^(medianBits-1) of the smallest.
+const medianBits = 5
+
+// sizeHistogram counts file sizes in buckets of exponentially growing width, so that the median can be
+// estimated within a few percent in constant memory rather than by keeping every size. The sizes below
+// 2^medianBits have a bucket each, larger ones share a bucket with those of the same leading medianBits bits.
+type sizeHistogram struct {
+	counts [(64 - medianBits + 2) << (medianBits - 1)]int64
+	total  int64
+}
+
+// bucket returns the index of the bucket holding size.
+func bucket(size int64) int {
+	if size < 1<<medianBits {
+		return int(size)
+	}
+	shift := bits.Len64(uint64(size)) - medianBits
+	return (shift+1)<<(medianBits-1) + int(size>>shift) - 1<<(medianBits-1)
+}
+
+// bucketRange returns the smallest and largest sizes of the i-th bucket.
+func bucketRange(i int) (lo, hi int64) {
+	if i < 1<<medianBits {
+		return int64(i), int64(i)
+	}
+	shift := i>>(medianBits-1) - 1
+	lo = int64(i-shift<<(medianBits-1)) << shift
+	return lo, lo + 1<<shift - 1
+}
+
+// add counts a file of size.
+func (h *sizeHistogram) add(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	h.counts[bucket(size)]++
+	h.total++
+}
+
+// median returns the middle of the bucket holding the median size, the lower one of an even number of sizes,
+// or 0 if no size was counted.
+func (h *sizeHistogram) median() int64 {
+	var seen int64
+	for i, n := range h.counts {
+		if seen += n; n > 0 && 2*seen >= h.total {
+			lo, hi := bucketRange(i)
+			return lo + (hi-lo)/2
+		}
+	}
+	return 0
+}
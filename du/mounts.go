@@ -0,0 +1,68 @@
+package du
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Mount is a mounted file system.
+type Mount struct {
+	Dev    uint64 // device id, as reported for the files on it
+	Path   string // mount point
+	Type   string // file system type, e.g. ext4 or nfs
+	Source string // device or remote share mounted, e.g. /dev/sda1 or server:/export
+}
+
+// parseMountInfo returns the mounts listed by r in the format of /proc/self/mountinfo.
+func parseMountInfo(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("invalid mount info line %q", scanner.Text())
+		}
+		major, minor, ok := strings.Cut(fields[2], ":")
+		maj, err1 := strconv.ParseUint(major, 10, 32)
+		min, err2 := strconv.ParseUint(minor, 10, 32)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid device %q in mount info", fields[2])
+		}
+		mounts = append(mounts, Mount{Dev: mkdev(maj, min), Path: unescapeMount(fields[4]), Type: fields[sep+1], Source: unescapeMount(fields[sep+2])})
+	}
+	return mounts, scanner.Err()
+}
+
+// mkdev returns the device id of the major and minor numbers, encoded as glibc does.
+func mkdev(major, minor uint64) uint64 {
+	return (major&0xfff)<<8 | (major&^0xfff)<<32 | minor&0xff | (minor&^0xff)<<12
+}
+
+// unescapeMount returns the mount info field s with its octal escapes of spaces, tabs, newlines and backslashes decoded.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package du
+
+import "os"
+
+// Mounts returns the mounted file systems listed by /proc/self/mountinfo, in mount order. The same device
+// may be mounted several times, e.g. by bind mounts.
+func Mounts() ([]Mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountInfo(f)
+}
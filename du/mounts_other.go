@@ -0,0 +1,10 @@
+//go:build !linux
+
+package du
+
+import "errors"
+
+// Mounts always fails as the mounted file systems are only listed on Linux.
+func Mounts() ([]Mount, error) {
+	return nil, errors.New("the mounted file systems can't be listed on this platform")
+}
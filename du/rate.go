@@ -0,0 +1,51 @@
+package du
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter spaces out operations to at most one per interval, for Options.MaxRate. A nil limiter doesn't limit.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // when the next operation may start
+}
+
+// newLimiter returns a limiter allowing rate operations per second, or nil if rate isn't positive.
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next operation may start, reserving its slot, and reports whether it may,
+// or returns false as soon as ctx is cancelled. Waiting goroutines sleep rather than spin.
+func (l *limiter) wait(ctx context.Context) bool {
+	if l == nil {
+		return ctx.Err() == nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(start)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
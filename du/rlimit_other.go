@@ -0,0 +1,8 @@
+//go:build !unix
+
+package du
+
+// openFilesLimit always reports false as there's no open files limit to query on this platform.
+func openFilesLimit() (n uint64, ok bool) {
+	return 0, false
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package du
+
+import "syscall"
+
+// openFilesLimit returns the soft limit on the number of files the process can open.
+func openFilesLimit() (n uint64, ok bool) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, false
+	}
+	return uint64(rlim.Cur), true
+}
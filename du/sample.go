@@ -0,0 +1,29 @@
+package du
+
+import "math"
+
+// extrapolate replaces the totals of the files sampled by Options.Sample in res by the estimated totals of every
+// file, the mean size of the sampled files times the number of files, overall and for each root with the number
+// of files sampled in each of them, and sets the margin of error from squares, the sum of their squared sizes.
+func extrapolate(res *Result, sampled map[string]int64, squares float64) {
+	n, files := float64(res.SampledFiles), float64(res.Files)
+	res.SampleMargin = -1
+	if n >= 2 {
+		mean := float64(res.Bytes) / n
+		variance := math.Max(0, (squares-n*mean*mean)/(n-1))
+		// 1.96 standard errors of the estimated total, corrected for sampling without replacement
+		res.SampleMargin = int64(1.96 * files * math.Sqrt(variance/n*(1-n/files)))
+	}
+	res.Bytes = estimate(res.Bytes, res.Files, res.SampledFiles)
+	for root, u := range res.PerRoot {
+		u.Bytes = estimate(u.Bytes, u.Files, sampled[root])
+	}
+}
+
+// estimate returns the estimated total size of files from the total size of the sampled ones.
+func estimate(bytes, files, sampled int64) int64 {
+	if sampled == 0 {
+		return 0
+	}
+	return int64(math.Round(float64(bytes) * float64(files) / float64(sampled)))
+}
@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package du
+
+import "os"
+
+// allocatedSize always reports false as block information isn't available on this platform,
+// so the apparent size is used instead.
+func allocatedSize(path string, info os.FileInfo) (size int64, ok bool) {
+	return 0, false
+}
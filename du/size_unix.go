@@ -0,0 +1,17 @@
+//go:build unix
+
+package du
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocatedSize returns the number of bytes allocated on disk for a file, which are counted in 512-byte blocks.
+func allocatedSize(path string, info os.FileInfo) (size int64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Blocks) * 512, true
+}
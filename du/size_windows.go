@@ -0,0 +1,64 @@
+//go:build windows
+
+package du
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                   = syscall.NewLazyDLL("kernel32.dll")
+	procGetCompressedFileSizeW = kernel32.NewProc("GetCompressedFileSizeW")
+	procGetDiskFreeSpaceW      = kernel32.NewProc("GetDiskFreeSpaceW")
+)
+
+// clusterSizes caches the cluster size of each volume, by volume root.
+var clusterSizes sync.Map
+
+// allocatedSize returns the number of bytes allocated on disk for a file: its size on disk as reported by
+// GetCompressedFileSizeW, which accounts for NTFS compression and sparse files, rounded up to a whole
+// number of clusters of its volume.
+func allocatedSize(path string, info os.FileInfo) (size int64, ok bool) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+	var high uint32
+	low, _, err := procGetCompressedFileSizeW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&high)))
+	if uint32(low) == 0xFFFFFFFF && err != syscall.Errno(0) { // INVALID_FILE_SIZE
+		return 0, false
+	}
+	size = int64(high)<<32 | int64(uint32(low))
+	if cluster := clusterSize(path); cluster > 0 {
+		size = (size + cluster - 1) / cluster * cluster
+	}
+	return size, true
+}
+
+// clusterSize returns the size of the clusters of the volume holding path, or 0 if it can't be queried.
+func clusterSize(path string) int64 {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0
+	}
+	root := filepath.VolumeName(abs) + `\`
+	if cluster, ok := clusterSizes.Load(root); ok {
+		return cluster.(int64)
+	}
+	var cluster int64
+	if p, err := syscall.UTF16PtrFromString(root); err == nil {
+		var sectorsPerCluster, bytesPerSector, freeClusters, totalClusters uint32
+		ok, _, _ := procGetDiskFreeSpaceW.Call(uintptr(unsafe.Pointer(p)),
+			uintptr(unsafe.Pointer(&sectorsPerCluster)), uintptr(unsafe.Pointer(&bytesPerSector)),
+			uintptr(unsafe.Pointer(&freeClusters)), uintptr(unsafe.Pointer(&totalClusters)))
+		if ok != 0 {
+			cluster = int64(sectorsPerCluster) * int64(bytesPerSector)
+		}
+	}
+	clusterSizes.Store(root, cluster)
+	return cluster
+}
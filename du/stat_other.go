@@ -0,0 +1,35 @@
+//go:build !unix
+
+package du
+
+import "os"
+
+// inode always reports false as inode information isn't available on this platform,
+// so directory loops through symbolic links can't be detected.
+func inode(info os.FileInfo) (id fileID, ok bool) {
+	return fileID{}, false
+}
+
+// linkID always reports false as inode information isn't available on this platform,
+// so hard links are counted once per link.
+func linkID(info os.FileInfo) (id fileID, ok bool) {
+	return fileID{}, false
+}
+
+// deviceID always reports false as device information isn't available on this platform,
+// so file system boundaries can't be detected.
+func deviceID(info os.FileInfo) (dev uint64, ok bool) {
+	return 0, false
+}
+
+// owner always reports false as ownership information isn't available on this platform,
+// so the files are left out of the owner totals.
+func owner(info os.FileInfo) (uid uint32, ok bool) {
+	return 0, false
+}
+
+// group always reports false as ownership information isn't available on this platform,
+// so no file matches a group filter.
+func group(info os.FileInfo) (gid uint32, ok bool) {
+	return 0, false
+}
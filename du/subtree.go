@@ -0,0 +1,44 @@
+package du
+
+import "sync/atomic"
+
+// subtree tracks the completion of a directory subtree while it is being walked, so its totals can be
+// reported as soon as the directory and all its descendants are done. Each subtree holds a reference on
+// its parent until it completes; memory is only used by the subtrees still in progress.
+type subtree struct {
+	parent   *subtree
+	pending  int64 // the directory itself plus its subdirectories that aren't complete yet
+	bytes    int64
+	files    int64
+	dirs     int64
+	symlinks int64
+	entries  int64 // entries of the directory itself, only set by the worker walking it before its release
+}
+
+// newSubtree returns the subtree of a directory, holding a reference on parent if it isn't nil.
+func newSubtree(parent *subtree) *subtree {
+	if parent != nil {
+		atomic.AddInt64(&parent.pending, 1)
+	}
+	return &subtree{parent: parent, pending: 1}
+}
+
+// add adds the totals of completed descendants or of the directory itself and its files.
+func (t *subtree) add(bytes, files, dirs, symlinks int64) {
+	atomic.AddInt64(&t.bytes, bytes)
+	atomic.AddInt64(&t.files, files)
+	atomic.AddInt64(&t.dirs, dirs)
+	atomic.AddInt64(&t.symlinks, symlinks)
+}
+
+// release drops one reference on t and reports whether the subtree is now complete. The totals of a
+// complete subtree are added to its parent, whose own reference must then be released by the caller.
+func (t *subtree) release() bool {
+	if atomic.AddInt64(&t.pending, -1) != 0 {
+		return false
+	}
+	if t.parent != nil {
+		t.parent.add(atomic.LoadInt64(&t.bytes), atomic.LoadInt64(&t.files), atomic.LoadInt64(&t.dirs), atomic.LoadInt64(&t.symlinks))
+	}
+	return true
+}
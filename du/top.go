@@ -0,0 +1,50 @@
+package du
+
+import "container/heap"
+
+// smaller reports whether a ranks below b, breaking ties in size by path so the report is deterministic.
+func smaller(a, b File) bool {
+	if a.Size != b.Size {
+		return a.Size < b.Size
+	}
+	return a.Path > b.Path
+}
+
+// topFiles is a min-heap holding the largest files seen so far, with the smallest of them at the top.
+type topFiles []File
+
+func (h topFiles) Len() int            { return len(h) }
+func (h topFiles) Less(i, j int) bool  { return smaller(h[i], h[j]) }
+func (h topFiles) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topFiles) Push(x interface{}) { *h = append(*h, x.(File)) }
+func (h *topFiles) Pop() interface{} {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
+
+// offer adds f to the heap if it is among the n largest files seen so far, keeping at most n files,
+// and returns the file left out, f itself or the one it replaced, if any.
+func (h *topFiles) offer(f File, n int) (dropped File, ok bool) {
+	if h.Len() < n {
+		heap.Push(h, f)
+		return File{}, false
+	}
+	if smaller((*h)[0], f) {
+		dropped = (*h)[0]
+		(*h)[0] = f
+		heap.Fix(h, 0)
+		return dropped, true
+	}
+	return f, true
+}
+
+// sorted empties the heap and returns its files from largest to smallest.
+func (h *topFiles) sorted() []File {
+	files := make([]File, h.Len())
+	for i := len(files) - 1; i >= 0; i-- {
+		files[i] = heap.Pop(h).(File)
+	}
+	return files
+}
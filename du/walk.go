@@ -0,0 +1,796 @@
+package du
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// result is sent by walkDir for every file and directory found during the walk.
+type result struct {
+	root      string // root directory the walk was started from
+	dir       string // directory the file was found in, or the directory itself if isDir is set
+	path      string // path of the file, or the directory itself if isDir is set
+	depth     int    // depth of dir below root, the root being at depth 0
+	size      int64
+	apparent  int64       // apparent size of a file, while size may be its allocated disk space
+	regular   bool        // set for a regular file, whose contents can be read
+	mode      os.FileMode // mode of a file
+	dangling  bool        // set for a symbolic link whose target doesn't exist if ByType is set
+	allocated int64       // allocated disk space of a regular file if Sparse is set and the platform reports it, or -1
+	onDisk    bool        // set if size is the allocated disk space of a file rather than its apparent size
+	sampled   int64       // number of files stated if Sample is set, 1 for a sampled file
+	squares   float64     // sum of the squared sizes of the sampled files
+	files     int64       // number of files in the subtree if done is set
+	symlinks  int64       // number of symbolic links among files if done or batch is set
+	dirs      int64       // number of directories in the subtree, including dir itself, if done is set, or below dir if batch is
+	entries   int64       // number of entries of dir itself if isDir is set
+	modTime   time.Time
+	uid       uint32            // owner of a file if owned is set
+	owned     bool              // set if ByOwner is set and the platform reports the owner of the file
+	dev       uint64            // device holding a file if hasDev is set
+	hasDev    bool              // set if ByDevice is set and the platform reports the device of the file
+	sum       [sha256.Size]byte // digest of the contents of a file if hashed is set
+	hashed    bool              // set if Checksum is set and the file could be read
+	isDir     bool
+	done      bool  // set with isDir once the subtree of dir is completely walked, size holding its total
+	batch     bool  // set for the totals of several files of dir sent at once, size holding their total and files their number
+	rolledUp  bool  // set if the totals were already added to the dirMap of the walk
+	empty     bool  // set for a directory read without error and without entries, or a file of apparent size 0
+	err       error // error reading the directory or the contents of the file, for Visit
+	failed    bool  // set for a file that couldn't be stated, only reporting err
+}
+
+// dirJob is a directory waiting in the queue to be walked.
+type dirJob struct {
+	root  string // root directory the walk was started from
+	dir   string
+	depth int    // depth of dir below root, the root being at depth 0
+	dev   uint64 // device of root if OneFileSystem is set
+
+	realRoot string      // root with symbolic links resolved if FollowLinks is set
+	tree     *subtree    // completion tracking of dir if DirDone, Visit or TopDirs is set
+	ignore   *ignoreList // rules of the .gitignore files of dir and its parents if GitIgnore is set
+	modTime  time.Time   // modification time of dir if Cache is set
+}
+
+// fileID identifies a file by device and inode numbers.
+type fileID struct {
+	dev, ino uint64
+}
+
+// fileIDSet is a concurrency safe set of files.
+type fileIDSet struct {
+	mu   sync.Mutex
+	seen map[fileID]struct{}
+}
+
+// add records id in the set and reports whether it wasn't there already.
+func (s *fileIDSet) add(id fileID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[id]; ok {
+		return false
+	}
+	s.seen[id] = struct{}{}
+	return true
+}
+
+// errorList is a concurrency safe list of errors.
+type errorList struct {
+	mu   sync.Mutex
+	errs []Error
+}
+
+// add appends the error reading path to the list.
+func (l *errorList) add(path string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, Error{Path: path, Err: err})
+}
+
+// list returns a copy of the errors added so far.
+func (l *errorList) list() []Error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Error(nil), l.errs...)
+}
+
+// walker holds the state shared by the workers of a walk.
+type walker struct {
+	opts    Options
+	fsys    fs.FS // Options.FS, or osFS
+	queue   chan dirJob
+	results chan result
+	n       sync.WaitGroup // counts the directories that have been found but not completely walked yet
+	sema    chan struct{}  // semaphore limiting the directories read at once to MaxOpen, to prevent too many open files
+	links   fileIDSet      // hard linked files counted so far, so the other links to them can be skipped
+	visited fileIDSet      // directories walked so far if FollowLinks is set, to break symbolic link loops
+	errs    errorList
+	limit   *limiter // limiter of MaxRate, or nil
+	found   int64    // directories found so far, updated atomically
+	read    int64    // directories read so far, updated atomically
+	level   int64    // directories read at once chosen by AutoTune, updated atomically
+	batch   bool     // set if no option needs a result for each file, the totals of the files of a directory being sent at once
+	dirs    *dirMap  // totals of each directory rolled up by the workers if PerDir and batch are set, or nil
+
+	includeExts, excludeExts map[string]bool  // Options.IncludeExts and ExcludeExts as returned by ext
+	only                     []*regexp.Regexp // Options.Only as returned by globRegexp
+	skipDevs, crossDevs      map[uint64]bool  // devices of Options.SkipMounts and CrossMounts
+}
+
+func newWalker(opts Options) *walker {
+	fsys := opts.FS
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	w := &walker{
+		opts:    opts,
+		fsys:    fsys,
+		queue:   make(chan dirJob, 1024),
+		results: make(chan result, opts.ResultBuffer),
+		sema:    make(chan struct{}, opts.MaxOpen),
+		links:   fileIDSet{seen: make(map[fileID]struct{})},
+		visited: fileIDSet{seen: make(map[fileID]struct{})},
+		limit:   newLimiter(opts.MaxRate),
+		batch:   !(needsEveryFile(opts) || opts.MaxFiles > 0),
+
+		includeExts: extSet(opts.IncludeExts),
+		excludeExts: extSet(opts.ExcludeExts),
+	}
+	if opts.PerDir && w.batch && opts.MaxDirs == 0 {
+		w.dirs = newDirMap()
+	}
+	return w
+}
+
+// send sends the result r of a directory or of a batch of files to the collector, adding it to the totals
+// of the directories first if the workers roll them up themselves.
+func (w *walker) send(r result) {
+	if w.dirs != nil {
+		w.dirs.rollUp(w, r)
+		r.rolledUp = true
+	}
+	w.results <- r
+}
+
+// devices returns the set of the devices holding paths, or nil if there are none.
+func (w *walker) devices(paths []string) (map[uint64]bool, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	devs := make(map[uint64]bool)
+	for _, path := range paths {
+		info, err := fs.Stat(w.fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		dev, ok := deviceID(info)
+		if !ok {
+			return nil, fmt.Errorf("%s: file system devices aren't reported on this platform", path)
+		}
+		devs[dev] = true
+	}
+	return devs, nil
+}
+
+// attrsMatch reports whether the owners and the permissions of a file match the UIDs, GIDs, PermAll and PermAny options.
+func (w *walker) attrsMatch(info os.FileInfo) bool {
+	if w.opts.UIDs != nil {
+		uid, ok := owner(info)
+		if !ok || !containsID(w.opts.UIDs, uid) {
+			return false
+		}
+	}
+	if w.opts.GIDs != nil {
+		gid, ok := group(info)
+		if !ok || !containsID(w.opts.GIDs, gid) {
+			return false
+		}
+	}
+	mode := info.Mode() & PermBits
+	return mode&w.opts.PermAll == w.opts.PermAll && (w.opts.PermAny == 0 || mode&w.opts.PermAny != 0)
+}
+
+// containsID reports whether ids holds id.
+func containsID(ids []uint32, id uint32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// extSet returns the set of extensions exts in the form returned by ext, or nil if there are none.
+func extSet(exts []string) map[string]bool {
+	if len(exts) == 0 {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, e := range exts {
+		set["."+strings.ToLower(strings.TrimPrefix(e, "."))] = true
+	}
+	return set
+}
+
+// start walks the directory root(s) concurrently with a pool of workers fed from the queue of directories.
+// results is closed once every directory has been walked.
+func (w *walker) start(ctx context.Context, roots []string) {
+	w.n.Add(len(roots))
+	atomic.AddInt64(&w.found, int64(len(roots)))
+	if w.opts.AutoTune {
+		max := w.opts.Threads
+		if max > w.opts.MaxOpen {
+			max = w.opts.MaxOpen
+		}
+		w.autotune(ctx, max)
+	}
+	for i := 0; i < w.opts.Threads; i++ {
+		go w.worker(ctx)
+	}
+	go func() {
+		for _, root := range roots {
+			w.queue <- w.rootJob(root)
+		}
+	}()
+	go func() {
+		w.n.Wait()
+		close(w.queue)
+		close(w.results)
+	}()
+}
+
+// worker walks the directories taken from the queue until it is closed.
+func (w *walker) worker(ctx context.Context) {
+	for job := range w.queue {
+		w.walkDir(ctx, job)
+	}
+}
+
+// Walks the file tree rooted at job.dir and sends a result for the directory and for each found file on results channel.
+// Subdirectories are pushed onto the queue for the other workers, or walked right away if the queue is full,
+// so a worker never blocks on the queue while holding a directory that n is waiting for.
+// Once ctx is cancelled the remaining directories are skipped, and the directories in progress never complete.
+// Directories with valid totals in Cache are walked from the cache instead.
+func (w *walker) walkDir(ctx context.Context, job dirJob) {
+	defer w.n.Done()
+	if ctx.Err() != nil {
+		return
+	}
+	w.log(slog.LevelDebug, "entering directory", "path", job.dir, "depth", job.depth)
+	if w.opts.Cache != nil {
+		if d, ok := w.opts.Cache.lookup(job.dir, job.modTime); ok {
+			w.log(slog.LevelDebug, "reusing cached directory", "path", job.dir)
+			atomic.AddInt64(&w.read, 1)
+			w.walkCached(ctx, job, d)
+			return
+		}
+	}
+	entries, err := w.dirents(ctx, job.dir)
+	atomic.AddInt64(&w.read, 1)
+	if err != nil {
+		w.errs.add(job.dir, err)
+		w.log(slog.LevelWarn, "unreadable directory", "path", job.dir, "error", err)
+	}
+	w.send(result{root: job.root, dir: job.dir, path: job.dir, depth: job.depth, isDir: true, entries: int64(len(entries)), empty: err == nil && len(entries) == 0, err: err})
+	if w.opts.GitIgnore {
+		job.ignore = w.readIgnore(job.dir, entries, job.ignore)
+	}
+	var bytes, files, symlinks int64 // totals of the files in job.dir itself
+	dirs := int64(1)                 // job.dir itself and the virtual directories of its archives
+	var cached cachedDir             // totals of the files in job.dir itself for Cache
+	batch := result{root: job.root, dir: job.dir, path: job.dir, depth: job.depth, batch: true}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		path := w.join(job.dir, entry.Name())
+		if w.excluded(entry.Name(), path) {
+			w.skip(slog.LevelDebug, path, "excluded")
+			continue
+		}
+		if w.opts.ExcludeHidden && hidden(entry) {
+			w.skip(slog.LevelDebug, path, "hidden")
+			continue
+		}
+		if job.ignore.ignored(path, entry.IsDir()) {
+			w.skip(slog.LevelDebug, path, "ignored by .gitignore")
+			continue
+		}
+		if !entry.IsDir() && !(w.opts.FollowLinks && entry.Type()&os.ModeSymlink != 0) && w.archiveKind(entry.Name()) == "" && !w.nameMatches(entry.Name(), path) {
+			w.skip(slog.LevelDebug, path, "name or path not matched")
+			continue // filtered out before the stat call, unlike links that may lead to directories or archives
+		}
+		info, err := w.entryInfo(path, entry)
+		if err != nil {
+			continue
+		}
+		if w.opts.FollowLinks && info.Mode()&os.ModeSymlink != 0 {
+			var ok bool
+			if info, ok = w.follow(job, path, info); !ok {
+				continue
+			}
+		}
+		if info.IsDir() {
+			if w.descend(ctx, job, path, info) && w.opts.Cache != nil {
+				cached.Subdirs = append(cached.Subdirs, entry.Name())
+			}
+		} else {
+			if kind := w.archiveKind(entry.Name()); kind != "" && info.Mode().IsRegular() {
+				if u, ok := w.walkArchive(ctx, job, path, kind); ok {
+					bytes, files, dirs, symlinks = bytes+u.Bytes, files+u.Files, dirs+u.Dirs, symlinks+u.Symlinks
+					continue
+				}
+			}
+			if !w.nameMatches(entry.Name(), path) {
+				w.skip(slog.LevelDebug, path, "name or path not matched")
+				continue
+			}
+			if info.Size() < w.opts.MinSize || (w.opts.MaxSize > 0 && info.Size() > w.opts.MaxSize) {
+				w.skip(slog.LevelDebug, path, "size out of range")
+				continue
+			}
+			if !w.opts.NewerThan.IsZero() && info.ModTime().Before(w.opts.NewerThan) || !w.opts.OlderThan.IsZero() && !info.ModTime().Before(w.opts.OlderThan) {
+				w.skip(slog.LevelDebug, path, "modification time out of range")
+				continue
+			}
+			if !w.attrsMatch(info) {
+				w.skip(slog.LevelDebug, path, "owner or permissions not matched")
+				continue
+			}
+			if w.opts.FileFilter != nil && !w.opts.FileFilter(entry.Name(), info) {
+				w.skip(slog.LevelDebug, path, "filter not matched")
+				continue
+			}
+			id, ok := linkID(info)
+			if w.opts.FollowLinks {
+				id, ok = inode(info) // even files with a single link can be reached through symbolic links
+			}
+			linked := ok && !w.opts.CountLinks
+			if linked && !w.links.add(id) {
+				if w.opts.Cache != nil {
+					size, _ := w.fileSize(path, info)
+					cached.Links = append(cached.Links, cachedLink{Dev: id.dev, Ino: id.ino, Size: size})
+				}
+				w.skip(slog.LevelDebug, path, "hard link to a file already counted")
+				continue
+			}
+			size, onDisk := w.fileSize(path, info)
+			if special(info.Mode()) {
+				size = 0
+			}
+			symlink := info.Mode()&os.ModeSymlink != 0
+			bytes += size
+			files++
+			if symlink {
+				symlinks++
+			}
+			if w.opts.Cache != nil {
+				if linked {
+					cached.Links = append(cached.Links, cachedLink{Dev: id.dev, Ino: id.ino, Size: size, Symlink: symlink})
+				} else {
+					cached.Bytes += size
+					cached.Files++
+					if symlink {
+						cached.Symlinks++
+					}
+				}
+				cached.OnDisk = cached.OnDisk || onDisk
+			}
+			var sampled int64
+			var squares float64
+			if _, skipped := info.(entryInfo); w.opts.Sample > 0 && !skipped {
+				sampled, squares = 1, float64(size)*float64(size)
+			}
+			if w.batch {
+				// sending a result for each file would make the collector the bottleneck of walks over many small files
+				batch.size, batch.files, batch.onDisk = batch.size+size, batch.files+1, batch.onDisk || onDisk
+				batch.sampled, batch.squares = batch.sampled+sampled, batch.squares+squares
+				if symlink {
+					batch.symlinks++
+				}
+				continue
+			}
+			r := result{root: job.root, dir: job.dir, path: path, depth: job.depth, size: size, onDisk: onDisk, apparent: info.Size(), regular: info.Mode().IsRegular(), modTime: info.ModTime(), empty: info.Size() == 0 && !w.opts.CountOnly}
+			r.sampled, r.squares = sampled, squares
+			r.allocated = -1
+			r.mode = info.Mode()
+			if w.opts.ByType && info.Mode()&os.ModeSymlink != 0 {
+				_, err := fs.Stat(w.fsys, path)
+				r.dangling = errors.Is(err, fs.ErrNotExist)
+			}
+			if w.opts.Sparse > 0 && r.regular && !w.opts.CountOnly && w.native() {
+				if allocated, ok := allocatedSize(path, info); ok {
+					r.allocated = allocated
+				}
+			}
+			if w.opts.ByOwner {
+				r.uid, r.owned = owner(info)
+			}
+			if w.opts.ByDevice {
+				r.dev, r.hasDev = deviceID(info)
+			}
+			if w.opts.Checksum && w.limit.wait(ctx) {
+				var err error
+				if r.sum, err = contentDigest(w.fsys, path, info); err != nil {
+					w.errs.add(path, err)
+					r.err = err
+				}
+				r.hashed = err == nil
+			}
+			w.results <- r
+		}
+	}
+	if batch.files > 0 {
+		w.send(batch)
+	}
+	if w.opts.Cache != nil && err == nil && ctx.Err() == nil {
+		cached.ModTime, cached.Entries = job.modTime, int64(len(entries))
+		w.opts.Cache.store(job.dir, cached)
+	}
+	if job.tree != nil {
+		job.tree.entries = int64(len(entries))
+		job.tree.add(bytes, files, dirs, symlinks)
+		w.complete(job)
+	}
+}
+
+// descend walks the subdirectory at path of the directory of job, pushing it onto the queue for the other workers,
+// or walking it right away if the queue is full. It reports whether the subdirectory is walked, unless it is
+// the mount point of a file system skipped or, with FollowLinks, a directory already walked.
+func (w *walker) descend(ctx context.Context, job dirJob, path string, info os.FileInfo) bool {
+	if dev, ok := deviceID(info); ok && (w.skipDevs[dev] || w.opts.OneFileSystem && dev != job.dev && !w.crossDevs[dev]) {
+		w.skip(slog.LevelInfo, path, "mount point of another file system")
+		return false
+	}
+	if id, ok := inode(info); w.opts.FollowLinks && ok && !w.visited.add(id) {
+		w.skip(slog.LevelInfo, path, "directory already walked")
+		return false
+	}
+	sub := dirJob{root: job.root, dir: path, depth: job.depth + 1, dev: job.dev, realRoot: job.realRoot, ignore: job.ignore, modTime: info.ModTime()}
+	if job.tree != nil {
+		sub.tree = newSubtree(job.tree)
+	}
+	if u, ok := w.opts.Completed[path]; ok {
+		w.resume(sub, u)
+		return true
+	}
+	w.n.Add(1)
+	atomic.AddInt64(&w.found, 1)
+	select {
+	case w.queue <- sub:
+	default:
+		w.walkDir(ctx, sub)
+	}
+	return true
+}
+
+// resume counts the subtree of job with the totals u of the interrupted walk that completed it instead of walking it.
+func (w *walker) resume(job dirJob, u Usage) {
+	w.send(result{root: job.root, dir: job.dir, path: job.dir, depth: job.depth, isDir: true, entries: u.Entries})
+	if u.Files > 0 || u.Dirs > 1 {
+		w.send(result{root: job.root, dir: job.dir, path: job.dir, depth: job.depth, size: u.Bytes, files: u.Files, dirs: u.Dirs - 1, symlinks: u.Symlinks, batch: true})
+	}
+	if job.tree != nil {
+		job.tree.entries = u.Entries
+		job.tree.add(u.Bytes, u.Files, u.Dirs, u.Symlinks)
+		w.complete(job)
+	}
+}
+
+// walkCached walks the directory of job from its cached totals d instead of reading it, only stating
+// its subdirectories to walk them, from the cache too unless they changed.
+func (w *walker) walkCached(ctx context.Context, job dirJob, d cachedDir) {
+	w.send(result{root: job.root, dir: job.dir, path: job.dir, depth: job.depth, isDir: true, entries: d.Entries})
+	bytes, files, symlinks := d.Bytes, d.Files, d.Symlinks
+	for _, l := range d.Links {
+		if w.links.add(fileID{dev: l.Dev, ino: l.Ino}) {
+			bytes += l.Size
+			files++
+			if l.Symlink {
+				symlinks++
+			}
+		}
+	}
+	if files > 0 {
+		w.send(result{root: job.root, dir: job.dir, path: job.dir, depth: job.depth, size: bytes, files: files, symlinks: symlinks, onDisk: d.OnDisk, batch: true})
+	}
+	for _, name := range d.Subdirs {
+		if ctx.Err() != nil {
+			return
+		}
+		path := w.join(job.dir, name)
+		info, err := fs.Stat(w.fsys, path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				w.errs.add(path, err)
+			}
+			continue
+		}
+		if info.IsDir() {
+			w.descend(ctx, job, path, info)
+		}
+	}
+	w.opts.Cache.store(job.dir, d)
+	if job.tree != nil {
+		job.tree.entries = d.Entries
+		job.tree.add(bytes, files, 1, symlinks)
+		w.complete(job)
+	}
+}
+
+// complete releases the reference the directory of job holds on its own subtree, sending a done result
+// for every subtree that completes as a consequence, from the directory up toward the root.
+func (w *walker) complete(job dirJob) {
+	for t, dir, depth := job.tree, job.dir, job.depth; t != nil && t.release(); t, dir, depth = t.parent, w.parent(dir), depth-1 {
+		w.results <- result{root: job.root, dir: dir, path: dir, depth: depth, size: t.bytes, files: t.files, dirs: t.dirs, symlinks: t.symlinks, entries: t.entries, isDir: true, done: true}
+	}
+}
+
+// excluded reports whether the name or the full path of an entry matches any of the Exclude patterns.
+func (w *walker) excluded(name, path string) bool {
+	if w.opts.IgnoreCase && len(w.opts.Exclude) > 0 {
+		name, path = strings.ToLower(name), strings.ToLower(path)
+	}
+	for _, pattern := range w.opts.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hidden reports whether entry is a hidden file or directory, whose name starts with a dot or,
+// on Windows, with the hidden attribute.
+func hidden(entry os.DirEntry) bool {
+	return strings.HasPrefix(entry.Name(), ".") || hiddenAttr(entry)
+}
+
+// readIgnore returns the rules of the .gitignore file among the entries of dir on top of parent,
+// or parent if there is none.
+func (w *walker) readIgnore(dir string, entries []os.DirEntry, parent *ignoreList) *ignoreList {
+	for _, entry := range entries {
+		if entry.Name() == ".gitignore" && entry.Type().IsRegular() {
+			path := w.join(dir, entry.Name())
+			data, err := fs.ReadFile(w.fsys, path)
+			if err != nil {
+				w.skip(slog.LevelWarn, path, err.Error())
+				return parent
+			}
+			return parseIgnore(dir, data, parent)
+		}
+	}
+	return parent
+}
+
+// rootJob returns the job walking root. If OneFileSystem is set it records the device of root so the walk
+// can stay on that file system, if FollowLinks is set it marks root as visited and resolves its real path,
+// and if Cache is set it records the modification time of root.
+func (w *walker) rootJob(root string) dirJob {
+	job := dirJob{root: root, dir: root}
+	if w.opts.DirDone != nil || w.opts.Checkpoint != nil || w.opts.Visit != nil || w.opts.TopDirs > 0 || w.opts.PerDir && w.opts.MaxDirs > 0 {
+		job.tree = newSubtree(nil)
+	}
+	if !w.opts.OneFileSystem && !w.opts.FollowLinks && w.opts.Cache == nil {
+		return job
+	}
+	info, err := fs.Stat(w.fsys, root)
+	if err != nil {
+		return job // reported when the walk fails to read root
+	}
+	job.dev, _ = deviceID(info)
+	job.modTime = info.ModTime()
+	if w.opts.FollowLinks {
+		if id, ok := inode(info); ok {
+			w.visited.add(id)
+		}
+		if job.realRoot, err = filepath.EvalSymlinks(root); err != nil {
+			job.realRoot = root
+		}
+	}
+	return job
+}
+
+// follow returns the target of the symbolic link at path, which is counted or walked instead of the link.
+// Dangling links are counted as the link itself, while links to targets outside of the root are skipped.
+func (w *walker) follow(job dirJob, path string, link os.FileInfo) (info os.FileInfo, ok bool) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return link, true
+	}
+	if rel, err := filepath.Rel(job.realRoot, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		w.skip(slog.LevelInfo, path, fmt.Sprintf("links to %s outside of %s", target, job.root))
+		return nil, false
+	}
+	if info, err = os.Stat(target); err != nil {
+		return link, true
+	}
+	return info, true
+}
+
+// skip notes that the entry at path is skipped for reason, on Logf unless level is below info and on Logger at level.
+func (w *walker) skip(level slog.Level, path, reason string) {
+	if w.opts.Logf != nil && level >= slog.LevelInfo {
+		w.opts.Logf("skipping %s: %s", path, reason)
+	}
+	w.log(level, "skipping entry", "path", path, "reason", reason)
+}
+
+// log logs a record with the message and attributes args on the Logger option at level if set.
+func (w *walker) log(level slog.Level, msg string, args ...interface{}) {
+	if w.opts.Logger != nil {
+		w.opts.Logger.Log(context.Background(), level, msg, args...)
+	}
+}
+
+// fileSize returns the disk space allocated to a file, or its apparent size if Apparent is set
+// or if the platform or Options.FS doesn't report allocated blocks, or zero if CountOnly is set,
+// rounded up to BlockSize. onDisk reports whether it is the allocated disk space.
+func (w *walker) fileSize(path string, info os.FileInfo) (size int64, onDisk bool) {
+	if w.opts.CountOnly {
+		return 0, false
+	}
+	size = info.Size()
+	if !w.opts.Apparent && w.native() {
+		size, onDisk = allocatedSize(path, info)
+		if !onDisk {
+			size = info.Size()
+		}
+	}
+	return w.roundBlock(size), onDisk
+}
+
+// roundBlock returns size rounded up to a multiple of BlockSize if set.
+func (w *walker) roundBlock(size int64) int64 {
+	if b := w.opts.BlockSize; b > 0 {
+		return (size + b - 1) / b * b
+	}
+	return size
+}
+
+// nameMatches reports whether the file of name at path passes the Match and NoMatch filters, the extension filters
+// and the Only globs.
+func (w *walker) nameMatches(name, path string) bool {
+	if w.only != nil && !w.onlyMatches(path) {
+		return false
+	}
+	if w.includeExts != nil || w.excludeExts != nil {
+		e := ext(name)
+		if w.excludeExts[e] || (w.includeExts != nil && !w.includeExts[e]) {
+			return false
+		}
+	}
+	return (w.opts.Match == nil || w.opts.Match.MatchString(name)) && (w.opts.NoMatch == nil || !w.opts.NoMatch.MatchString(name))
+}
+
+// onlyMatches reports whether path matches any of the Only globs.
+func (w *walker) onlyMatches(path string) bool {
+	path = filepath.ToSlash(path)
+	for _, re := range w.only {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryInfo returns the file information of the directory entry at path, only paying for a stat call when
+// it is needed: for files unless CountOnly is set or Sample leaves them out, and for directories if OneFileSystem,
+// SkipMounts or FollowLinks need to identify them. The other entries report a size of zero. Entries removed since the directory was read
+// are skipped, and the others that can't be stated are reported as errors.
+func (w *walker) entryInfo(path string, entry os.DirEntry) (os.FileInfo, error) {
+	stat := !w.opts.CountOnly && (w.opts.Sample == 0 || rand.Float64() < w.opts.Sample)
+	if entry.IsDir() {
+		stat = w.opts.OneFileSystem || w.opts.FollowLinks || w.skipDevs != nil || w.opts.Cache != nil
+	}
+	if !stat {
+		return entryInfo{entry}, nil
+	}
+	info, err := entry.Info()
+	if err != nil && !os.IsNotExist(err) {
+		w.errs.add(path, err)
+		w.log(slog.LevelWarn, "unreadable entry", "path", path, "error", err)
+		if w.opts.Visit != nil {
+			w.results <- result{path: path, err: err, failed: true}
+		}
+	}
+	return info, err
+}
+
+// entryInfo is the file information of a directory entry that wasn't stated, with a size of zero.
+type entryInfo struct {
+	os.DirEntry
+}
+
+func (i entryInfo) Size() int64        { return 0 }
+func (i entryInfo) Mode() os.FileMode  { return i.Type() }
+func (i entryInfo) ModTime() time.Time { return time.Time{} }
+func (i entryInfo) Sys() interface{}   { return nil }
+
+// dirents returns the entries of directory dir in directory order, or no entries and no error if ctx is cancelled
+// while waiting for MaxRate, for a token or for the directory to be read. On the operating system's file system it neither
+// sorts nor stats them. A read failing with a transient error is retried up to Retries times with a backoff.
+// The directory is read by a goroutine holding the token, so a read hung on a dead mount only blocks that goroutine.
+func (w *walker) dirents(ctx context.Context, dir string) ([]os.DirEntry, error) {
+	delay := w.opts.RetryDelay
+	for retry := 1; ; retry++ {
+		entries, err := w.readDir(ctx, dir)
+		if err == nil || retry > w.opts.Retries || !transient(err) {
+			return entries, err
+		}
+		if w.opts.Logf != nil {
+			w.opts.Logf("retrying %s in %v (%d of %d): %v", dir, delay, retry, w.opts.Retries, err)
+		}
+		w.log(slog.LevelWarn, "retrying directory read", "path", dir, "retry", retry, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil
+		}
+		delay *= 2
+	}
+}
+
+// transient reports whether err may not happen again when retrying, unlike permanent errors like ENOENT or EACCES.
+func transient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EINTR, syscall.EAGAIN, syscall.EIO, syscall.ETIMEDOUT, syscall.EBUSY} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// readDir reads the entries of dir once, like dirents.
+func (w *walker) readDir(ctx context.Context, dir string) ([]os.DirEntry, error) {
+	if !w.limit.wait(ctx) {
+		return nil, nil
+	}
+	select {
+	case w.sema <- struct{}{}: // acquire token
+	case <-ctx.Done():
+		return nil, nil
+	}
+	type dirRead struct {
+		entries []os.DirEntry
+		err     error
+	}
+	done := make(chan dirRead, 1)
+	go func() {
+		defer func() { <-w.sema }() // release token
+		begin := time.Now()
+		entries, err := fs.ReadDir(w.fsys, dir)
+		if elapsed := time.Since(begin); w.opts.Logger != nil {
+			level := slog.LevelDebug
+			if elapsed >= w.opts.SlowRead {
+				level = slog.LevelWarn
+			}
+			w.log(level, "read directory", "path", dir, "entries", len(entries), "duration", elapsed)
+		}
+		done <- dirRead{entries, err}
+	}()
+	select {
+	case r := <-done:
+		return r.entries, r.err
+	case <-ctx.Done():
+		return nil, nil
+	}
+}
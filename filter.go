@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// filterExpr is a predicate over the files parsed by parseFilter. Its fields are compared with the apparent size,
+// the age since the last modification, the lowercased extension without its dot and the name of each file.
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" expr ")" | field op value
+//	field   = "size" | "age" | "ext" | "name"
+//	op      = "<" | "<=" | ">" | ">=" | "==" | "!="
+//
+// Sizes are written like for -minsize, ages like for -age-buckets, and the names are shell patterns compared
+// with == and != only, like the extensions, e.g. 'size>1G && age>365d || name==*.iso'. A value holding spaces
+// or operators is quoted with ' or ".
+type filterExpr interface {
+	match(name string, info fs.FileInfo) bool
+}
+
+type orExpr struct{ left, right filterExpr }
+type andExpr struct{ left, right filterExpr }
+type notExpr struct{ expr filterExpr }
+
+// cmpExpr compares a field of the files with a value with op.
+type cmpExpr struct {
+	field, op, text string // text is the value of ext and name
+	value           int64  // value of size, and of age in nanoseconds
+}
+
+func (e orExpr) match(name string, info fs.FileInfo) bool {
+	return e.left.match(name, info) || e.right.match(name, info)
+}
+
+func (e andExpr) match(name string, info fs.FileInfo) bool {
+	return e.left.match(name, info) && e.right.match(name, info)
+}
+
+func (e notExpr) match(name string, info fs.FileInfo) bool {
+	return !e.expr.match(name, info)
+}
+
+func (e cmpExpr) match(name string, info fs.FileInfo) bool {
+	switch e.field {
+	case "size":
+		return compare(info.Size(), e.op, e.value)
+	case "age":
+		return compare(int64(time.Since(info.ModTime())), e.op, e.value)
+	case "ext":
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+		return (ext == e.text) == (e.op == "==")
+	default:
+		ok, _ := filepath.Match(e.text, name)
+		return ok == (e.op == "==")
+	}
+}
+
+// compare returns the comparison op of a and b.
+func compare(a int64, op string, b int64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "==":
+		return a == b
+	default:
+		return a != b
+	}
+}
+
+// filterParser parses a filter expression from its tokens.
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseFilter returns the predicate of the filter expression s, see filterExpr.
+func parseFilter(s string) (filterExpr, error) {
+	tokens, err := filterTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return expr, err
+}
+
+// filterTokens splits s into operators, parentheses and values, unquoting the quoted values.
+func filterTokens(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at %q", s[i:])
+			}
+			tokens = append(tokens, "\x00"+s[i+1:i+1+end]) // marked as a value, even if it reads like an operator
+			i += end + 2
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||") || strings.HasPrefix(s[i:], "<=") ||
+			strings.HasPrefix(s[i:], ">=") || strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!="):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.IndexByte("()!<>", c) >= 0:
+			tokens = append(tokens, s[i:i+1])
+			i++
+		default:
+			end := strings.IndexFunc(s[i:], func(r rune) bool {
+				return unicode.IsSpace(r) || strings.ContainsRune("()!<>=&|'\"", r)
+			})
+			if end < 0 {
+				end = len(s) - i
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("unexpected %q", s[i:])
+			}
+			tokens = append(tokens, "\x00"+s[i:i+end])
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+// next returns the next token, or "" at the end of the expression.
+func (p *filterParser) next() string {
+	if p.pos == len(p.tokens) {
+		return ""
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+// peek returns the next token without consuming it.
+func (p *filterParser) peek() string {
+	if p.pos == len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) or() (filterExpr, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right filterExpr
+		right, err = p.and()
+		left = orExpr{left, right}
+	}
+	return left, err
+}
+
+func (p *filterParser) and() (filterExpr, error) {
+	left, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right filterExpr
+		right, err = p.unary()
+		left = andExpr{left, right}
+	}
+	return left, err
+}
+
+func (p *filterParser) unary() (filterExpr, error) {
+	switch tok := p.next(); tok {
+	case "!":
+		expr, err := p.unary()
+		return notExpr{expr}, err
+	case "(":
+		expr, err := p.or()
+		if err == nil && p.next() != ")" {
+			err = fmt.Errorf("missing )")
+		}
+		return expr, err
+	case "":
+		return nil, fmt.Errorf("unexpected end of the expression")
+	default:
+		return p.comparison(tok)
+	}
+}
+
+// comparison parses the comparison of field with the value following its operator.
+func (p *filterParser) comparison(field string) (filterExpr, error) {
+	if !strings.HasPrefix(field, "\x00") {
+		return nil, fmt.Errorf("unexpected %q", field)
+	}
+	e := cmpExpr{field: strings.TrimPrefix(field, "\x00"), op: p.next()}
+	switch e.op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("missing comparison operator after %s", e.field)
+	}
+	value := p.next()
+	if !strings.HasPrefix(value, "\x00") {
+		return nil, fmt.Errorf("missing value after %s%s", e.field, e.op)
+	}
+	value = value[1:]
+	var err error
+	switch e.field {
+	case "size":
+		e.value, err = parseSize(value)
+	case "age":
+		var age time.Duration
+		age, err = parseAge(value)
+		e.value = int64(age)
+	case "ext", "name":
+		if e.op != "==" && e.op != "!=" {
+			return nil, fmt.Errorf("%s can only be compared with == and !=", e.field)
+		}
+		e.text = value
+		if e.field == "ext" {
+			e.text = strings.ToLower(strings.TrimPrefix(value, "."))
+		} else if _, err = filepath.Match(value, ""); err != nil {
+			err = fmt.Errorf("name pattern %q: %v", value, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown field %q, want size, age, ext or name", e.field)
+	}
+	return e, err
+}
+
+// filterValue is a flag holding a filter expression parsed by parseFilter.
+type filterValue struct {
+	text string
+	expr filterExpr
+}
+
+func (v *filterValue) String() string {
+	return v.text
+}
+
+func (v *filterValue) Set(s string) error {
+	expr, err := parseFilter(s)
+	if err != nil {
+		return err
+	}
+	v.text, v.expr = s, expr
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// testInfo is the fs.FileInfo of a file of the given size and modification time.
+type testInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (i testInfo) Name() string       { return "" }
+func (i testInfo) Size() int64        { return i.size }
+func (i testInfo) Mode() fs.FileMode  { return 0o644 }
+func (i testInfo) ModTime() time.Time { return i.modTime }
+func (i testInfo) IsDir() bool        { return false }
+func (i testInfo) Sys() interface{}   { return nil }
+
+func TestParseFilter(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tt := range []struct {
+		expr string
+		name string
+		size int64
+		age  time.Duration
+		want bool
+	}{
+		{"size>1 || size<0 && name==x", "y", 5, 0, true}, // && binds tighter than ||
+		{"(size>1 || size<0) && name==x", "y", 5, 0, false},
+		{"!size>1", "y", 5, 0, false},
+		{"!(size>1 && name==y)", "y", 5, 0, false},
+		{"!!size>1", "y", 5, 0, true},
+		{"size>1 && !name==y || ext==txt", "y", 5, 0, false},
+		{"name=='a b&&c'", "a b&&c", 0, 0, true},
+		{`name=="x>1 || y"`, "x>1 || y", 0, 0, true},
+		{"name=='x>1' || size==0", "x", 1, 0, false},
+		{"size>=1.5K", "a", 1536, 0, true},
+		{"size>=1.5K", "a", 1535, 0, false},
+		{"size==1MB", "a", 1000000, 0, true},
+		{"size==1M", "a", 1 << 20, 0, true},
+		{"size<=2GiB && size!=0", "a", 2 << 30, 0, true},
+		{"age>30d", "a", 0, 40 * day, true},
+		{"age>30d", "a", 0, 20 * day, false},
+		{"age<2h", "a", 0, time.Hour, true},
+		{"age>=1w && age<1y", "a", 0, 8 * day, true},
+		{"ext==txt", "a.TXT", 0, 0, true},
+		{"ext==.TXT", "a.txt", 0, 0, true},
+		{"ext!=txt", "a.log", 0, 0, true},
+		{"ext==txt", "txt", 0, 0, false},
+		{"name==*.iso", "disk.iso", 0, 0, true},
+		{"name!=*.iso", "disk.iso", 0, 0, false},
+	} {
+		expr, err := parseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if got := expr.match(tt.name, testInfo{tt.size, time.Now().Add(-tt.age)}); got != tt.want {
+			t.Errorf("%q on %q of %d bytes and %v old: got %v, want %v", tt.expr, tt.name, tt.size, tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"size>",
+		"size>1 &&",
+		"|| size>1",
+		"size>1 size<2",
+		"(size>1",
+		"size>1)",
+		"()",
+		"size 1",
+		"size>>1",
+		"color==red",
+		"ext<txt",
+		"name>=a",
+		"size>abc",
+		"size>1X",
+		"age>soon",
+		"name==[",
+		"name=='a",
+		"!",
+		"==1",
+	} {
+		if _, err := parseFilter(expr); err == nil {
+			t.Errorf("%q: got no error", expr)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/robert-mcdermott/godu
+
+go 1.21
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// previousRun is the latest complete run over the same roots recorded in the -db database, for -growth.
+type previousRun struct {
+	startedAt string
+	dirs      map[string]int64 // total size of each directory subtree, by displayPath
+	changes   []growth         // directories of this run that changed since, set by compare
+}
+
+// lastRun is the previous run loaded for -growth, or nil if there is none.
+var lastRun *previousRun
+
+// growth is a directory whose size changed since the previous run, or that appeared or disappeared since.
+type growth struct {
+	path          string
+	before, after int64
+	added         bool // set if the directory didn't exist in the previous run
+	removed       bool // set if the directory doesn't exist anymore
+}
+
+// growthReport is the JSON form of the -growth report.
+type growthReport struct {
+	Since     string         `json:"since"`
+	Increases []growthRecord `json:"increases"`
+	Relative  []growthRecord `json:"relative_increases"`
+	Added     []growthRecord `json:"added"`
+	Removed   []growthRecord `json:"removed"`
+}
+
+// growthRecord is the JSON form of a directory of the -growth report.
+type growthRecord struct {
+	Path        string  `json:"path"`
+	Bytes       int64   `json:"bytes"`
+	BeforeBytes int64   `json:"before_bytes"`
+	Percent     float64 `json:"percent,omitempty"`
+}
+
+// loadPreviousRun returns the latest complete run over the roots recorded in the SQLite database at path,
+// reading it with the sqlite3 command, or nil if there is none yet.
+func loadPreviousRun(path string, roots []string) (*previousRun, error) {
+	query := dbSchema + fmt.Sprintf(`SELECT r.started_at, hex(e.path), e.bytes FROM entries e JOIN runs r ON r.id = e.run_id
+	WHERE e.run_id = (SELECT max(id) FROM runs WHERE roots = %s AND partial = 0);`, sqlQuote(strings.Join(roots, "\n")))
+	cmd := exec.Command("sqlite3", "-bail", "-ascii", path)
+	cmd.Stdin, cmd.Stderr = strings.NewReader(query), os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading the previous run with sqlite3: %v", err)
+	}
+	return parsePreviousRun(out)
+}
+
+// parsePreviousRun parses the rows of started_at, path and bytes fields output by sqlite3 in ascii mode,
+// separated by the ASCII unit (0x1f) and record (0x1e) separators, the paths being hex encoded as they may
+// hold the separators.
+func parsePreviousRun(out []byte) (*previousRun, error) {
+	var run *previousRun
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0x1e); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\x1f")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected sqlite3 output %q", scanner.Text())
+		}
+		path, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected sqlite3 output %q", scanner.Text())
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected sqlite3 output %q", scanner.Text())
+		}
+		if run == nil {
+			run = &previousRun{startedAt: fields[0], dirs: make(map[string]int64)}
+		}
+		run.dirs[displayPath(string(path))] = size
+	}
+	return run, scanner.Err()
+}
+
+// compare records the directories of res whose size changed since run, and those that appeared or disappeared,
+// before -exclude-empty drops the empty ones from res.
+func (run *previousRun) compare(res du.Result) {
+	run.changes = nil
+	for path, u := range res.Dirs {
+		before, ok := run.dirs[path]
+		if !ok || before != u.Bytes {
+			run.changes = append(run.changes, growth{path: path, before: before, after: u.Bytes, added: !ok})
+		}
+	}
+	for path, before := range run.dirs {
+		if _, ok := res.Dirs[path]; !ok {
+			run.changes = append(run.changes, growth{path: path, before: before, removed: true})
+		}
+	}
+}
+
+// percent returns the growth of g in percent of its previous size.
+func (g growth) percent() float64 {
+	return float64(g.after-g.before) * 100 / float64(g.before)
+}
+
+// rankGrowths returns the top n growths of gs kept by keep, sorted by less and then by path.
+func rankGrowths(gs []growth, n int, keep func(growth) bool, less func(a, b growth) bool) []growth {
+	var kept []growth
+	for _, g := range gs {
+		if keep(g) {
+			kept = append(kept, g)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if less(kept[i], kept[j]) != less(kept[j], kept[i]) {
+			return less(kept[i], kept[j])
+		}
+		return kept[i].path < kept[j].path
+	})
+	if len(kept) > n {
+		kept = kept[:n]
+	}
+	return kept
+}
+
+// growthRanks returns the -growth directories with the largest increases in bytes and in percentage,
+// and the largest directories that appeared and that disappeared since run.
+func growthRanks(run *previousRun) (increases, relative, added, removed []growth) {
+	gs := run.changes
+	n := *growthFlag
+	grew := func(g growth) bool { return !g.added && !g.removed && g.after > g.before }
+	increases = rankGrowths(gs, n, grew, func(a, b growth) bool { return a.after-a.before > b.after-b.before })
+	relative = rankGrowths(gs, n, func(g growth) bool { return grew(g) && g.before > 0 }, func(a, b growth) bool { return a.percent() > b.percent() })
+	added = rankGrowths(gs, n, func(g growth) bool { return g.added }, func(a, b growth) bool { return a.after > b.after })
+	removed = rankGrowths(gs, n, func(g growth) bool { return g.removed }, func(a, b growth) bool { return a.before > b.before })
+	return increases, relative, added, removed
+}
+
+// Prints the directories that grew the most since the previous run recorded in the -db database, in bytes and in
+// percentage, and the largest ones that appeared or disappeared since
+func printGrowth(w io.Writer, run *previousRun) {
+	if run == nil {
+		fmt.Fprintf(w, "\nGrowth: no previous complete run of the same roots recorded yet\n")
+		return
+	}
+	increases, relative, added, removed := growthRanks(run)
+	fmt.Fprintf(w, "\nGrowth since %s:\n", run.startedAt)
+	for _, g := range increases {
+		fmt.Fprintf(w, "%s\t%s -> %s\t%s\n", signedSize(g.after-g.before), formatSize(g.before), formatSize(g.after), g.path)
+	}
+	fmt.Fprintf(w, "\nRelative growth since %s:\n", run.startedAt)
+	for _, g := range relative {
+		fmt.Fprintf(w, "%+.1f%%\t%s -> %s\t%s\n", g.percent(), formatSize(g.before), formatSize(g.after), g.path)
+	}
+	if len(added) > 0 {
+		fmt.Fprintf(w, "\nNew directories:\n")
+		for _, g := range added {
+			fmt.Fprintf(w, "%s\t%s\n", coloredSize(g.after), g.path)
+		}
+	}
+	if len(removed) > 0 {
+		fmt.Fprintf(w, "\nRemoved directories:\n")
+		for _, g := range removed {
+			fmt.Fprintf(w, "%s\t%s\n", coloredSize(g.before), g.path)
+		}
+	}
+}
+
+// growthJSON returns the JSON form of the -growth report, or nil if there is no previous run.
+func growthJSON(run *previousRun) *growthReport {
+	if run == nil {
+		return nil
+	}
+	records := func(gs []growth) []growthRecord {
+		rs := []growthRecord{}
+		for _, g := range gs {
+			r := growthRecord{Path: g.path, Bytes: g.after, BeforeBytes: g.before}
+			if g.before > 0 && !g.removed {
+				r.Percent = g.percent()
+			}
+			rs = append(rs, r)
+		}
+		return rs
+	}
+	increases, relative, added, removed := growthRanks(run)
+	return &growthReport{Since: run.startedAt, Increases: records(increases), Relative: records(relative), Added: records(added), Removed: records(removed)}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+func TestGrowthExcludeEmpty(t *testing.T) {
+	defer func(n int, exclude bool) { *growthFlag, *excludeEmptyFlag = n, exclude }(*growthFlag, *excludeEmptyFlag)
+	*growthFlag, *excludeEmptyFlag = 10, true
+	run := &previousRun{startedAt: "2026-01-01", dirs: map[string]int64{"root": 100, "root/empty": 0}}
+	res := du.Result{Dirs: map[string]*du.Usage{"root": {Bytes: 150}, "root/empty": {}}}
+	run.compare(res)
+	dropEmpty(&res)
+	if res.Dirs["root/empty"] != nil {
+		t.Fatalf("root/empty not dropped from %v", res.Dirs)
+	}
+	rep := growthJSON(run)
+	if len(rep.Removed) != 0 || len(rep.Added) != 0 || len(rep.Increases) != 1 || rep.Increases[0].Path != "root" {
+		t.Errorf("got %+v, want only root growing", rep)
+	}
+}
+
+func TestGrowthRanks(t *testing.T) {
+	defer func(n int) { *growthFlag = n }(*growthFlag)
+	*growthFlag = 3
+	run := &previousRun{dirs: map[string]int64{
+		"root": 1000, "root/a": 100, "root/b": 100, "root/c": 200, "root/gone": 300, "root/gone2": 10,
+		"root/shrunk": 50, "root/same": 5, "root/zero": 0,
+	}}
+	res := du.Result{Dirs: map[string]*du.Usage{
+		"root": {Bytes: 1500}, "root/a": {Bytes: 300}, "root/b": {Bytes: 300}, "root/c": {Bytes: 500},
+		"root/shrunk": {}, "root/same": {Bytes: 5}, "root/zero": {Bytes: 10}, "root/new": {Bytes: 400}, "root/new2": {Bytes: 40},
+	}}
+	run.compare(res)
+	paths := func(gs []growth) string {
+		var s []string
+		for _, g := range gs {
+			s = append(s, g.path)
+		}
+		return strings.Join(s, " ")
+	}
+	increases, relative, added, removed := growthRanks(run)
+	for _, tt := range []struct {
+		name      string
+		got, want string
+	}{
+		{"increases", paths(increases), "root root/c root/a"}, // root/a and root/b tie, sorted by path
+		{"relative", paths(relative), "root/a root/b root/c"}, // root/zero grew from 0 bytes
+		{"added", paths(added), "root/new root/new2"},
+		{"removed", paths(removed), "root/gone root/gone2"}, // root/shrunk still exists with 0 bytes
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+	for _, g := range run.changes {
+		if g.path == "root/same" {
+			t.Errorf("root/same didn't change, got %+v", g)
+		}
+		if g.path == "root/shrunk" && (g.added || g.removed || g.before != 50 || g.after != 0) {
+			t.Errorf("root/shrunk: got %+v, want a change from 50 to 0 bytes", g)
+		}
+	}
+	rep := growthJSON(run)
+	if len(rep.Relative) != 3 || rep.Relative[0].Percent != 200 || rep.Relative[2].Percent != 150 || rep.Removed[0].Percent != 0 {
+		t.Errorf("got %+v, want the percentages of the relative increases only", rep)
+	}
+	*growthFlag = 1
+	if increases, relative, added, removed := growthRanks(run); len(increases) != 1 || len(relative) != 1 || len(added) != 1 || len(removed) != 1 {
+		t.Errorf("got %d, %d, %d and %d directories, want at most 1 of each", len(increases), len(relative), len(added), len(removed))
+	}
+}
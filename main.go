@@ -2,125 +2,924 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"os/signal"
+	"os/user"
 	"path/filepath"
+	"regexp"
 	"runtime"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/robert-mcdermott/godu/du"
 )
 
 // define and set default command parameter flags
-var vFlag = flag.Bool("v", false, "Optional: show verbose progress messages")
-var tFlag = flag.Int("t", runtime.NumCPU(), "Optional: set number of threads, defaults to number of logical cores")
+var stdinFlag = flag.Bool("stdin", false, "Optional: also walk the paths read from stdin, one per line, like a - root does")
+var logLevelFlag = slog.LevelInfo
+var logJSONFlag = flag.Bool("logjson", false, "Optional: with -loglevel, log JSON lines instead of text")
+var slowReadFlag = flag.Duration("slow-read", time.Second, "Optional: with -loglevel, log a warning for each directory whose read lasts at least `DURATION`")
+var vFlag = flag.Bool("v", false, "Optional: show verbose progress messages, with a rough ETA from the rates the directories are found and read at")
+var qFlag = flag.Bool("q", false, "Optional: print nothing, neither the results nor the progress and error messages, the exit status still reports errors")
+var tFlag = flag.Int("t", runtime.NumCPU(), "Optional: set number of threads and directory walking workers, defaults to number of logical cores")
+var maxrateFlag = flag.Float64("maxrate", 0, "Optional: read at most `N` directories per second, and files per second with -checksum and -dupes, to scan network file systems politely")
+var autotuneFlag = flag.Bool("autotune", false, "Optional: start reading 2 directories at once and read more of them while the rate of directories read keeps improving, settling once it stops, to suit spinning disks, SSDs and network file systems alike; -t and -maxopen cap the number, -t defaulting to -maxopen, and -v reports the number chosen")
+var chanbufFlag = flag.Int("chanbuf", 256, "Optional: set number of results buffered between the walking threads and the totals, for throughput tuning")
+var retriesFlag = flag.Int("retries", 3, "Optional: retry reading a directory failing with a transient error like EINTR or EIO, e.g. on a flaky NFS mount, up to `N` times with an increasing delay, noted with -v, 0 for never")
+var maxopenFlag = flag.Int("maxopen", du.DefaultMaxOpen(), "Optional: set number of directories read at once, defaults to 256 or half of the open files limit if lower")
+var progressFlag = flag.Bool("progress", false, "Optional: show the progress stats on a single line updated in place")
+var rootProgressFlag = flag.Bool("root-progress", false, "Optional: show the progress stats of each root on its own line, all updated in place, to see which roots are slow")
+var dFlag = flag.Bool("d", false, "Optional: show the total size of each directory subtree")
+var inodesFlag = flag.Bool("inodes", false, "Optional: show the number of entries, files and directories, of each directory subtree instead of its size, most first")
+var tuiFlag = flag.Bool("tui", false, "Optional: browse the sizes of the directories and files interactively once the walk is done, descending into the directories and going back up with the arrow keys")
+var treeFlag = flag.Bool("tree", false, "Optional: show the total size of each directory subtree as an indented tree, down to -maxdepth")
+var asciiFlag = flag.Bool("ascii", false, "Optional: with -tree, draw the branches with plain ASCII characters instead of box-drawing ones")
+var sampleFlag = flag.Float64("sample", 0, "Optional: only read the sizes of the `RATE` fraction of the files (e.g. 0.01) picked at random, still counting every file, and estimate the totals of the roots from their mean size with a margin of error, for a ballpark over a huge tree; it can't be combined with the directory totals nor the reports listing files")
+var countOnlyFlag = flag.Bool("count-only", false, "Optional: only count files and directories without reading their sizes, much faster on network file systems, directories are shown with their number of entries")
+var countsFlag = flag.Bool("counts", false, "Optional: with -d, -s and -csv, also show the number of files, directories and symbolic links of each directory subtree, to spot those heavy in entries rather than bytes")
+var sFlag = flag.Bool("s", false, "Optional: show the total size of each root")
+var totalFlag = flag.Bool("total", false, "Optional: show the grand total of all the roots on a line like those of -s, after them if combined with it")
+var hFlag = flag.Bool("h", false, "Optional: print sizes in human readable format using powers of 1024 (e.g. 1.5 MB)")
+var siFlag = flag.Bool("si", false, "Optional: like -h, but use powers of 1000")
+var mindepthFlag = flag.Int("mindepth", 0, "Optional: with -d, only show directories at least `N` levels below the roots, the roots being at level 0, shallower directories and their own files still counting toward the totals")
+var maxdepthFlag = flag.Int("maxdepth", -1, "Optional: with -d, only show directories at most `N` levels below the roots, deeper directories still count toward the totals")
+var maxEntriesFlag = flag.Int("max-entries", 0, "Optional: with -d, only keep the `N` largest directory subtrees, bounding the memory used on huge trees, and sum up the smaller ones on a last line")
+var lFlag = flag.Bool("l", false, "Optional: count sizes many times if hard linked, by default each hard linked file is only counted once")
+var apparentFlag = flag.Bool("apparent", false, "Optional: count apparent file sizes instead of the disk space allocated to files")
+var xFlag = flag.Bool("x", false, "Optional: skip directories on different file systems than their root")
+var LFlag = flag.Bool("L", false, "Optional: follow symbolic links to files and directories within the same root")
+var mergeRootsFlag = flag.Bool("merge-roots", false, "Optional: walk only once the roots that are the same directory, e.g. through symbolic links or bind mounts, instead of counting it once per root")
+var abspathFlag = flag.Bool("abspath", false, "Optional: print absolute paths, whether the roots are given as relative paths like . or as absolute ones")
+var relativeFlag = flag.String("relative", "", "Optional: print the paths relative to the `base` directory, e.g. . for the current one, whether the roots are given as relative or absolute paths")
+var followRootsFlag = flag.Bool("follow-root-symlinks", false, "Optional: walk and report the targets of the roots that are symbolic links, e.g. /mnt/bigdisk for /data -> /mnt/bigdisk, without following the links below them unlike -L")
+var topFlag = flag.Int("top", 0, "Optional: report the N largest files")
+var biggestFlag = flag.Int("biggest", 0, "Optional: report the `N` largest directory subtrees below the roots, without listing every directory like -d")
+var sparseFlag = flag.Int("sparse", 0, "Optional: report the N sparse files with the most unallocated space, and the totals of the space saved by sparse files and wasted by block rounding")
+var depthSizesFlag = flag.Bool("depth-sizes", false, "Optional: show the totals of the directories at each depth below the roots and of their own files, the roots being at depth 0")
+var archivesFlag = flag.Bool("archives", false, "Optional: count the contents of .tar, .tar.gz, .tgz and .zip files with their uncompressed sizes as if the archives were directories, e.g. file.zip/inner/path, instead of the archive files")
+var typesFlag = flag.Bool("types", false, "Optional: show the number and the total size of the entries of each type: regular files, directories, symbolic links, dangling symbolic links, devices, sockets and pipes")
+var byExtFlag = flag.Bool("by-ext", false, "Optional: show the totals of each file extension, largest first")
+var byFSFlag = flag.Bool("by-fs", false, "Optional: show the totals of each mounted file system holding the files and of each file system type (e.g. ext4, xfs or nfs), listed from /proc on Linux")
+var byOwnerFlag = flag.Bool("by-owner", false, "Optional: show the totals of each file owner, largest first")
+var ageFlag = flag.Bool("age", false, "Optional: show the totals of files by modification time age")
+var ageBucketsFlag = agesValue{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour, 365 * 24 * time.Hour}
+var fileStatsFlag = flag.Bool("file-stats", false, "Optional: show the mean and median file sizes, the median being estimated within 3%, to tell many small files from few large ones")
+var histFlag = flag.Bool("hist", false, "Optional: show a histogram of the number and total size of files by size range")
+var histBucketsFlag = sizesValue{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30}
+var minFilesFlag = flag.Int64("min-files", 0, "Optional: only show directories with at least `N` entries of their own, implies -d")
+var minFilesSubtreeFlag = flag.Bool("min-files-subtree", false, "Optional: with -min-files, count the entries of the whole subtree of each directory instead")
+var thresholdFlag sizeValue
+var colorFlag = colorValue("auto")
+var minsizeFlag, maxsizeFlag sizeValue
+var blockSizeFlag sizeValue
+var unitFlag unitValue
+var includeExtFlag, excludeExtFlag extsValue
+var skipDevFlag, onlyDevFlag pathsValue
+var newerFlag, olderFlag dateValue
+var uidFlag = idsValue{lookup: func(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}}
+var gidFlag = idsValue{lookup: func(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}}
+var permFlag permValue
+var sortFlag = sortValue("size")
+var longPathsFlag = flag.Int("long-paths", 0, "Optional: list the files and directories whose path is longer than `N` characters, longest first, e.g. before migrating to a file system or object store limiting them, with -abspath to measure the absolute paths")
+var excludeEmptyFlag = flag.Bool("exclude-empty", false, "Optional: leave the directories and files of 0 bytes out of the -d, -tree, -csv, -top, -biggest, -by-ext, -by-owner, -ndjson and -files-json reports, and out of -tui, still counting them")
+var emptyFlag = flag.Bool("empty", false, "Optional: list the empty directories and the files of size 0")
+var matchFlag, nomatchFlag regexpValue
+var filterFlag filterValue
+var print0Flag = flag.Bool("print0", false, "Optional: print only the paths listed by -d, -top, -biggest, -empty, -long-paths and -dupes, each ending with a NUL character instead of a newline, for xargs -0 and paths containing newlines")
+var dupesFlag = flag.Bool("dupes", false, "Optional: list the files with identical contents, only reading the files of the same size")
+var gitignoreFlag = flag.Bool("gitignore", false, "Optional: skip files and directories ignored by the .gitignore files found in the walked directories")
+var checksumFlag = flag.Bool("checksum", false, "Optional: read every file and print a checksum of the paths and contents of the tree, the same for identical trees, and with -v the digest of each file (use -l to include every hard link)")
+var excludeFlag, onlyFlag patterns
+var ignoreCaseFlag = flag.Bool("ignore-case", false, "Optional: match the -exclude and -only patterns and the -match and -nomatch expressions case insensitively, e.g. for the same tree scanned on Linux and macOS, the extensions of -include-ext and -exclude-ext always matching so")
+var excludeHiddenFlag = flag.Bool("exclude-hidden", false, "Optional: skip hidden files and directories, whose name starts with a dot or with the hidden attribute on Windows, the roots being walked even if hidden")
+var ndjsonFlag = flag.Bool("ndjson", false, "Optional: stream the total of each directory subtree as a JSON line as soon as it is walked, followed by the summary, progress messages are suppressed")
+var filesJSONFlag = flag.Bool("files-json", false, "Optional: stream a JSON line with the path, size, modification time and mode of every file counted as it is found, instead of the summary, e.g. to load them into a database")
+var csvFlag = flag.Bool("csv", false, "Optional: print the total size of each directory subtree as CSV rows of path, bytes and files instead of the summary")
+var promFlag = flag.Bool("prom", false, "Optional: print the totals of each root and the scan stats as Prometheus metrics instead of the summary, e.g. for the node_exporter textfile collector")
+var noHeaderFlag = flag.Bool("no-header", false, "Optional: with -csv, omit the header row")
+var oFlag = flag.String("o", "", "Optional: write the results to `file` instead of stdout")
+var diffFlag = flag.String("diff", "", "Optional: compare the apparent sizes of the files and directories of the root with those of the `other` tree, e.g. a mirror, listing the changed, missing and extra ones")
+var maxFilesFlag = flag.Int64("max-files", 0, "Optional: stop the walk once `N` files are counted and print the partial totals, as a safety valve against scanning much more than intended")
+var timeoutFlag = flag.Duration("timeout", 0, "Optional: stop the walk after `DURATION` (e.g. 30s or 5m) and print the partial totals, even if reading a directory hangs")
+var resumeFlag = flag.String("resume", "", "Optional: record the totals of the subtrees below the roots to the JSON lines `file` as each one is walked, and if it exists, count those recorded by an interrupted run from it instead of walking them again; the file is removed once a walk completes")
+var cacheFlag = flag.String("cache", "", "Optional: reuse the totals of the directories whose modification time didn't change since the previous run with the same cache `file`, and update it, to rescan a mostly static tree quickly")
+var verifyDUFlag = flag.Bool("verify-du", false, "Optional: self-test comparing the apparent size of each root, directories included, with the total of the system du -sb instead of the usual results, exiting with status 1 on any discrepancy; only -t, -maxopen, -x and -l apply")
+var growthFlag = flag.Int("growth", 0, "Optional: with -db, list the `N` directories that grew the most in bytes and in percentage since the previous complete run over the same roots recorded in the database, and the largest ones that appeared or disappeared since")
+var dbFlag = flag.String("db", "", "Optional: record the run and the total of each directory subtree in the SQLite database `file`, created if needed, using the sqlite3 command line shell of SQLite, which must be on the PATH")
+var ignoreErrorsFlag = flag.Bool("ignore-errors", false, "Optional: exit with status 0 even if some directories couldn't be read")
+var eventsFlag = flag.Bool("events", false, "Optional: print a JSON line with the progress stats at each progress update and a final one with done set instead of the summary, progress messages are suppressed")
+var noTimingFlag = flag.Bool("no-timing", false, "Optional: leave the elapsed time and files per second out of the results, so runs over an unchanged tree print identical results")
+var jsonFlag = flag.Bool("json", false, "Optional: print the final summary as a JSON object, progress messages are suppressed")
+
+func init() {
+	flag.TextVar(&logLevelFlag, "loglevel", slog.LevelInfo, "Optional: log on stderr each directory entered and read with its duration (debug), each slow read and unreadable entry (warn) and each skipped entry with the reason (debug for the filters, info otherwise), at or above `level`: debug, info or warn")
+	flag.BoolVar(dFlag, "per-dir", false, "Optional: same as -d")
+	flag.BoolVar(sFlag, "summarize", false, "Optional: same as -s")
+	flag.BoolVar(totalFlag, "grand-total", false, "Optional: same as -total")
+	flag.BoolVar(apparentFlag, "apparent-size", false, "Optional: same as -apparent")
+	flag.BoolVar(xFlag, "one-file-system", false, "Optional: same as -x")
+	flag.Var(&skipDevFlag, "skip-dev", "Optional: skip directories on the file system holding `path`, e.g. /proc or a network share (repeatable)")
+	flag.Var(&onlyDevFlag, "only-dev", "Optional: like -x, but also walk directories on the file system holding `path`, e.g. a data volume mounted below the root (repeatable)")
+	flag.Var(&sortFlag, "sort", "Optional: with -d, the `order` of the directories: size, files or entries for largest first, name for by path, or any of them prefixed with - to reverse it")
+	flag.Var(&colorFlag, "color", "Optional: color the sizes of the directories and the other tables by magnitude, green below 1GB, yellow below 100GB and red above: auto if the output is a terminal, always or never (`when`)")
+	flag.Var(&thresholdFlag, "threshold", "Optional: with -d, only show directories of at least `SIZE` (e.g. 100M), or at most -SIZE if negative")
+	flag.Var(&ageBucketsFlag, "age-buckets", "Optional: with -age, the comma separated `ages` bounding the buckets, in days (d), weeks (w), years (y) or Go durations")
+	flag.Var(&histBucketsFlag, "hist-buckets", "Optional: with -hist, the comma separated `sizes` bounding the size ranges (e.g. 1K,1M,1G)")
+	flag.Var(&minsizeFlag, "minsize", "Optional: only count files of at least `SIZE` (e.g. 500k, 1.5G or a number of bytes)")
+	flag.Var(&maxsizeFlag, "maxsize", "Optional: only count files of at most `SIZE` (e.g. 500k, 1.5G or a number of bytes)")
+	flag.Var(&newerFlag, "newer", "Optional: only count files modified at or after `DATE`, an RFC 3339 time (e.g. 2024-01-31T12:00:00Z), a local date and time (e.g. 2024-01-31 or 2024-01-31 12:00) or an age (e.g. 30d)")
+	flag.Var(&olderFlag, "older", "Optional: only count files modified before `DATE`, in any form accepted by -newer, which it can be combined with to count a time window")
+	flag.Var(&unitFlag, "B", "Optional: print sizes as whole numbers of `unit`, rounded up and aligned in columns, e.g. K, M, G or T for powers of 1024, KB, MB, GB or TB for powers of 1000, which are appended to the sizes, or a number of bytes like 1048576; overrides -h and -si, and -csv prints the bytes column in it without the suffix")
+	flag.Var(&blockSizeFlag, "block-size", "Optional: round the size of each file up to a multiple of `SIZE` (e.g. 4K) before adding it up, to match the totals of du on file systems with that block size, best with -apparent")
+	flag.Var(&includeExtFlag, "include-ext", "Optional: only count files with one of the comma separated `extensions` (e.g. mp4,mkv), matched case insensitively with or without a leading dot (repeatable)")
+	flag.Var(&excludeExtFlag, "exclude-ext", "Optional: don't count files with one of the comma separated `extensions` (e.g. tmp,log), which wins over -include-ext for the extensions given to both (repeatable)")
+	flag.Var(&uidFlag, "uid", "Optional: only count files owned by one of the comma separated `users`, given by name or id (repeatable)")
+	flag.Var(&gidFlag, "gid", "Optional: only count files owned by one of the comma separated `groups`, given by name or id (repeatable)")
+	flag.Var(&permFlag, "perm", "Optional: only count files with all the permission `bits`, in octal (e.g. 0002) or symbolic form (e.g. o+w or u+s,g+s), or any of them if prefixed with / (e.g. /6000)")
+	flag.Var(&filterFlag, "filter", "Optional: only count files matching the `expression` comparing their size, age, ext and name, combined with &&, || and ! and grouped with parentheses, e.g. 'size>1G && age>365d' or 'ext==log || name==\"core.*\"'; sizes and ages are written like for -minsize and -age-buckets, names are shell patterns, ext and name only compare with == and !=")
+	flag.Var(&matchFlag, "match", "Optional: only count files whose name matches the regular `expression`")
+	flag.Var(&nomatchFlag, "nomatch", "Optional: don't count files whose name matches the regular `expression`")
+	flag.Var(excludeFromValue{&excludeFlag}, "exclude-from", "Optional: like -exclude for each shell pattern read from `file`, one per line, ignoring blank lines and # comments (repeatable)")
+	flag.Var(&onlyFlag, "only", "Optional: only count files whose full path matches the shell `pattern`, ** matching any number of directories, e.g. '**/*.parquet', still walking every directory to find them (repeatable)")
+	flag.Var(&excludeFlag, "exclude", "Optional: skip files and directories matching the shell `pattern`, against either the name or the full path (repeatable)")
+}
+
+// patterns is a repeatable flag holding shell file name patterns.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%q: %v", pattern, err)
+	}
+	*p = append(*p, pattern)
+	return nil
+}
+
+// excludeFromValue is a repeatable flag adding the patterns read from files to the exclude patterns.
+type excludeFromValue struct {
+	exclude *patterns
+}
+
+func (v excludeFromValue) String() string {
+	return ""
+}
+
+func (v excludeFromValue) Set(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := v.exclude.Set(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+// pathsValue is a repeatable flag holding paths.
+type pathsValue []string
+
+func (v *pathsValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *pathsValue) Set(path string) error {
+	*v = append(*v, path)
+	return nil
+}
+
+// extsValue is a repeatable flag holding comma separated file extensions.
+type extsValue []string
+
+func (v *extsValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *extsValue) Set(s string) error {
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			*v = append(*v, e)
+		}
+	}
+	return nil
+}
+
+// idsValue is a repeatable flag holding comma separated user or group ids, given as numbers or names
+// looked up by lookup.
+type idsValue struct {
+	ids    []uint32
+	lookup func(name string) (string, error)
+}
+
+func (v *idsValue) String() string {
+	if v == nil {
+		return ""
+	}
+	ids := make([]string, len(v.ids))
+	for i, id := range v.ids {
+		ids[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (v *idsValue) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			found, lerr := v.lookup(name)
+			if lerr != nil {
+				return lerr
+			}
+			if id, err = strconv.ParseUint(found, 10, 32); err != nil {
+				return fmt.Errorf("%s has the non-numeric id %q", name, found)
+			}
+		}
+		v.ids = append(v.ids, uint32(id))
+	}
+	return nil
+}
+
+// permValue is a flag holding permission bits, given in octal (e.g. 0002) or symbolically (e.g. o+w or u+s,g+s),
+// that files must all have, or any of them if prefixed with / as with find -perm.
+type permValue struct {
+	mode fs.FileMode
+	any  bool
+	text string
+}
+
+func (v *permValue) String() string {
+	return v.text
+}
+
+func (v *permValue) Set(s string) error {
+	text, anyBits := s, strings.HasPrefix(s, "/")
+	if anyBits {
+		s = s[1:]
+	} else {
+		s = strings.TrimPrefix(s, "-")
+	}
+	mode, err := parsePerm(s)
+	if err != nil {
+		return err
+	}
+	*v = permValue{mode: mode, any: anyBits, text: text}
+	return nil
+}
+
+// parsePerm returns the permission bits in octal (e.g. 4755) or symbolic form (e.g. o+w or u+s,g+s).
+func parsePerm(s string) (fs.FileMode, error) {
+	if n, err := strconv.ParseUint(s, 8, 32); err == nil {
+		if n > 0o7777 {
+			return 0, fmt.Errorf("invalid permissions %q", s)
+		}
+		mode := fs.FileMode(n) & fs.ModePerm
+		for bit, flag := range map[uint64]fs.FileMode{0o4000: fs.ModeSetuid, 0o2000: fs.ModeSetgid, 0o1000: fs.ModeSticky} {
+			if n&bit != 0 {
+				mode |= flag
+			}
+		}
+		return mode, nil
+	}
+	var mode fs.FileMode
+	for _, clause := range strings.Split(s, ",") {
+		who, perms, ok := strings.Cut(clause, "+")
+		if !ok || perms == "" || strings.Trim(who, "ugoa") != "" || strings.Trim(perms, "rwxst") != "" {
+			return 0, fmt.Errorf("invalid permissions %q, want octal bits or e.g. o+w", s)
+		}
+		if who == "" {
+			who = "a"
+		}
+		for _, w := range who {
+			for _, p := range perms {
+				mode |= permBit(w, p)
+			}
+		}
+	}
+	return mode, nil
+}
+
+// permBit returns the permission bit p (r, w, x, s or t) of who (u, g, o or a for all of them).
+func permBit(who, p rune) fs.FileMode {
+	if who == 'a' {
+		return permBit('u', p) | permBit('g', p) | permBit('o', p)
+	}
+	shift := map[rune]uint{'u': 6, 'g': 3, 'o': 0}[who]
+	switch p {
+	case 'r':
+		return 0o4 << shift
+	case 'w':
+		return 0o2 << shift
+	case 'x':
+		return 0o1 << shift
+	case 's':
+		return map[rune]fs.FileMode{'u': fs.ModeSetuid, 'g': fs.ModeSetgid}[who]
+	default: // t
+		if who == 'o' {
+			return fs.ModeSticky
+		}
+		return 0
+	}
+}
+
+// regexpValue is a flag holding a regular expression, compiled when the flag is parsed.
+type regexpValue struct {
+	*regexp.Regexp
+}
+
+func (v *regexpValue) String() string {
+	if v.Regexp == nil {
+		return ""
+	}
+	return v.Regexp.String()
+}
+
+func (v *regexpValue) Set(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	v.Regexp = re
+	return nil
+}
+
+// readRoots returns the paths read from r, one per line, trimming the surrounding white space and quotes
+// and skipping blank lines.
+func readRoots(r io.Reader) ([]string, error) {
+	var roots []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		root := strings.TrimSpace(scanner.Text())
+		if n := len(root); n >= 2 && (root[0] == '"' || root[0] == '\'') && root[n-1] == root[0] {
+			root = root[1 : n-1]
+		}
+		if root != "" {
+			roots = append(roots, root)
+		}
+	}
+	return roots, scanner.Err()
+}
+
+// isFlagSet reports whether the flag name was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
 // Program starts here
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "\nUsage: %s [-v, -t int] topdir1 topdirN\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nExample: %s -v /home/rmcdermo /fh/fast/mcdermott_r /fh/secure/research/mcdermott_r\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nUsage: %s [options] topdir1 topdirN, or - to read them from stdin\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nExample: %s -v /home/rmcdermo /fh/fast/mcdermott_r /fh/secure/research/mcdermott_r\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nThe results are printed on stdout, the progress and error messages on stderr.\n\n")
 		flag.PrintDefaults()
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
 	}
-	start := time.Now().Unix()
+	start := time.Now()
 	flag.Parse()
+	if (*inodesFlag || *countOnlyFlag) && !isFlagSet("sort") {
+		sortFlag = "entries"
+	}
 	runtime.GOMAXPROCS(*tFlag)
 
-	// Get the directory root(s) to start the file walk(s)
-	roots := flag.Args()
-	if len(roots) == 0 {
+	// Get the directory root(s) to start the file walk(s), reading them from stdin in place of '-' or if the '-stdin' flag was provided
+	var roots []string
+	stdin := *stdinFlag
+	for _, arg := range flag.Args() {
+		if arg == "-" {
+			stdin = true
+		} else {
+			roots = append(roots, arg)
+		}
+	}
+	if stdin {
+		paths, err := readRoots(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			os.Exit(1)
+		}
+		roots = append(roots, paths...)
+	} else if len(roots) == 0 {
 		roots = []string{"."}
 	}
+	if *followRootsFlag {
+		roots = resolveRoots(roots)
+	}
+	if *mergeRootsFlag {
+		roots = mergeRoots(roots)
+	}
+	roots, err := absRoots(roots)
+	if err == nil && *relativeFlag != "" {
+		relativeBase, err = filepath.Abs(*relativeFlag)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Walk the directory root(s) concurrently
-	fileSizes := make(chan int64, 256)
+	opts := du.Options{
+		Threads:       *tFlag,
+		MaxOpen:       *maxopenFlag,
+		Retries:       *retriesFlag,
+		ResultBuffer:  *chanbufFlag,
+		MaxRate:       *maxrateFlag,
+		PerDir:        *dFlag || *csvFlag || *inodesFlag || *treeFlag || *minFilesFlag > 0 || *dbFlag != "",
+		MaxDepth:      *maxdepthFlag,
+		MinDepth:      *mindepthFlag,
+		MaxDirs:       *maxEntriesFlag,
+		Top:           *topFlag,
+		TopDirs:       *biggestFlag,
+		Sparse:        *sparseFlag,
+		Exclude:       excludeFlag,
+		Only:          onlyFlag,
+		IgnoreCase:    *ignoreCaseFlag,
+		ExcludeHidden: *excludeHiddenFlag,
+		GitIgnore:     *gitignoreFlag,
+		CountLinks:    *lFlag,
+		Apparent:      *apparentFlag,
+		MaxFiles:      *maxFilesFlag,
+		BlockSize:     int64(blockSizeFlag),
+		IncludeExts:   includeExtFlag,
+		ExcludeExts:   excludeExtFlag,
+		NewerThan:     time.Time(newerFlag),
+		OlderThan:     time.Time(olderFlag),
+		OneFileSystem: *xFlag || len(onlyDevFlag) > 0,
+		SkipMounts:    skipDevFlag,
+		CrossMounts:   onlyDevFlag,
+		ByExt:         *byExtFlag,
+		ByDepth:       *depthSizesFlag,
+		ByType:        *typesFlag,
+		Archives:      *archivesFlag,
+		ByOwner:       *byOwnerFlag,
+		ByDevice:      *byFSFlag,
+		FollowLinks:   *LFlag,
+		FindEmpty:     *emptyFlag,
+		LongPaths:     *longPathsFlag,
+		FindDupes:     *dupesFlag,
+		Checksum:      *checksumFlag,
+		Median:        *fileStatsFlag,
+		CountOnly:     *countOnlyFlag,
+		Match:         matchFlag.Regexp,
+		NoMatch:       nomatchFlag.Regexp,
+		MinSize:       int64(minsizeFlag),
+		MaxSize:       int64(maxsizeFlag),
+		UIDs:          uidFlag.ids,
+		GIDs:          gidFlag.ids,
+		AutoTune:      *autotuneFlag,
+		Sample:        *sampleFlag,
+	}
+	if filterFlag.expr != nil {
+		opts.FileFilter = filterFlag.expr.match
+	}
+	if *autotuneFlag && !isFlagSet("t") {
+		opts.Threads = 0 // up to -maxopen workers in du.Options
+	}
+	if *retriesFlag <= 0 {
+		opts.Retries = -1 // 0 stands for the default number of retries in du.Options
+	}
+	if permFlag.any {
+		opts.PermAny = permFlag.mode
+	} else {
+		opts.PermAll = permFlag.mode
+	}
+	if *ageFlag {
+		opts.AgeBuckets = ageBucketsFlag
+	}
+	if *histFlag {
+		opts.SizeBuckets = histBucketsFlag
+	}
 
-	var n sync.WaitGroup
-	for _, root := range roots {
-		n.Add(1)
-		go walkDir(root, &n, fileSizes)
+	// If the '-diff' flag was provided, keep the sizes of every file and directory of both trees to compare them
+	if *diffFlag != "" {
+		if len(roots) != 1 {
+			fmt.Fprintf(os.Stderr, "du: -diff needs exactly one root to compare with %s\n", *diffFlag)
+			os.Exit(1)
+		}
+		opts.PerDir, opts.PerFile, opts.Apparent = true, true, true
+		opts.MinDepth, opts.MaxDepth, opts.MaxDirs = 0, -1, 0
+	}
+
+	if *mindepthFlag > 0 && *treeFlag {
+		fmt.Fprintf(os.Stderr, "du: -mindepth can't be combined with -tree, which draws every directory from the roots down\n")
+		os.Exit(1)
+	}
+	if *maxEntriesFlag > 0 && *treeFlag {
+		fmt.Fprintf(os.Stderr, "du: -max-entries can't be combined with -tree, which draws every directory from the roots down\n")
+		os.Exit(1)
+	}
+
+	if *dbFlag != "" {
+		if err := checkSQLite(); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *growthFlag > 0 && *dbFlag == "" {
+		fmt.Fprintf(os.Stderr, "du: -growth needs -db to compare the run with the previous one recorded in the database\n")
+		os.Exit(1)
+	}
+
+	// If the '-cache' flag was provided, reuse the totals of the directories that didn't change since the previous run
+	if *cacheFlag != "" {
+		cache, err := loadCache(*cacheFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "du: %s: %v\n", *cacheFlag, err)
+			os.Exit(1)
+		}
+		opts.Cache = cache
+	}
+
+	// If the '-resume' flag was provided, skip the subtrees completed by the interrupted run and record those of this one
+	var resume *checkpoint
+	if *resumeFlag != "" {
+		if resume, err = openCheckpoint(*resumeFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %s: %v\n", *resumeFlag, err)
+			os.Exit(1)
+		}
+		opts.Completed, opts.Checkpoint = resume.completed, resume.add
+	}
+
+	// If the '-tui' flag was provided, keep the sizes of every directory and file to browse them
+	if *tuiFlag {
+		opts.PerDir, opts.PerFile = true, true
+		opts.MinDepth, opts.MaxDepth, opts.MaxDirs = 0, -1, 0
+	}
+
+	// If the '-o' flag was provided, write the results to the file instead of stdout
+	outFile := os.Stdout
+	if *oFlag != "" {
+		f, err := os.Create(*oFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			os.Exit(1)
+		}
+		outFile = f
+	}
+	out := bufio.NewWriter(outFile)
+	setColor(outFile)
+
+	// If the '-ndjson' flag was provided, stream the directory totals instead of keeping them until the end
+	if *ndjsonFlag && !*qFlag {
+		opts.PerDir = false
+		enc := json.NewEncoder(out)
+		opts.DirDone = func(path string, u du.Usage) {
+			if *excludeEmptyFlag && u.Bytes == 0 {
+				return
+			}
+			enc.Encode(dirReport{Path: displayPath(path), Bytes: u.Bytes, Files: u.Files, Dirs: u.Dirs, Entries: u.Entries, Symlinks: u.Symlinks})
+			out.Flush() // each line is read as soon as its directory completes
+		}
 	}
+
+	// If the '-files-json' flag was provided, stream the files instead of the summary
+	if *filesJSONFlag && !*qFlag {
+		enc := json.NewEncoder(out)
+		opts.Visit = func(ev du.FileEvent) {
+			if !ev.IsDir && ev.Err == nil && !(*excludeEmptyFlag && ev.Size == 0) {
+				enc.Encode(fileRecord{Path: displayPath(ev.Path), Size: ev.Size, MTime: ev.ModTime.Format(time.RFC3339Nano), Mode: ev.Mode.String()})
+				out.Flush()
+			}
+		}
+	}
+
+	// If the '-v' flag was provided, periodically print the progress stats unless the output is JSON,
+	// and report the skipped entries on stderr
+	if *vFlag && !*jsonFlag && !*ndjsonFlag && !*eventsFlag && !*filesJSONFlag && !*qFlag {
+		var eta etaEstimate
+		etaText := "unknown"
+		opts.DirProgress = func(found, read int64) {
+			etaText = eta.update(found, read, time.Now())
+		}
+		opts.Progress = func(nfiles, nbytes int64) {
+			printProgress(nfiles, nbytes, start, etaText)
+		}
+	}
+	if *progressFlag && !*qFlag {
+		opts.ProgressInterval = 200 * time.Millisecond
+		spins := 0
+		opts.Progress = func(nfiles, nbytes int64) {
+			printProgressLine(nfiles, nbytes, start, spins)
+			spins++
+		}
+	}
+	rootLines := 0 // number of lines printed by printRootProgress
+	if *rootProgressFlag && !*qFlag {
+		opts.ProgressInterval = 200 * time.Millisecond
+		opts.Progress = nil
+		var last []du.Usage
+		opts.RootProgress = func(roots []string, totals []du.Usage) {
+			printRootProgress(roots, totals, last, opts.ProgressInterval, rootLines)
+			rootLines, last = len(roots), totals
+		}
+	}
+	// If the '-events' flag was provided, print the progress stats as JSON lines on the results output
+	if *eventsFlag && !*qFlag {
+		opts.Progress = func(nfiles, nbytes int64) {
+			printEvent(out, event{Files: nfiles, Bytes: nbytes}, start)
+			out.Flush()
+		}
+	}
+	// Print the progress stats once on SIGUSR1, like -v does periodically, if nothing else prints them
+	if opts.Progress == nil && opts.RootProgress == nil && !*qFlag {
+		var eta etaEstimate
+		etaText := "unknown"
+		opts.DirProgress = func(found, read int64) {
+			etaText = eta.update(found, read, time.Now())
+		}
+		opts.Progress = func(nfiles, nbytes int64) {
+			printProgress(nfiles, nbytes, start, etaText)
+		}
+		opts.ProgressInterval = -1
+	}
+	opts.ProgressNow = notifyProgress()
+	// If the '-checksum' and '-v' flags were provided, keep the digest of each file to print them by path like sha256sum does
+	var fileSums []string
+	if *checksumFlag && *vFlag && !*qFlag && !*jsonFlag && !*ndjsonFlag && !*csvFlag && !*eventsFlag {
+		opts.FileSum = func(path string, sum [sha256.Size]byte) {
+			fileSums = append(fileSums, fmt.Sprintf("%x  %s", sum, displayPath(path)))
+		}
+	}
+	if (isFlagSet("loglevel") || *logJSONFlag) && !*qFlag {
+		handlerOpts := &slog.HandlerOptions{Level: logLevelFlag}
+		if *logJSONFlag {
+			opts.Logger = slog.New(slog.NewJSONHandler(os.Stderr, handlerOpts))
+		} else {
+			opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, handlerOpts))
+		}
+		opts.SlowRead = *slowReadFlag
+	} else if *vFlag && !*qFlag {
+		opts.Logf = func(format string, args ...interface{}) {
+			fmt.Fprintf(os.Stderr, "du: "+format+"\n", args...)
+		}
+	}
+
+	// Stop the walk on Ctrl-C or SIGTERM and report the partial totals, a second signal kills the program
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	go func() {
-		n.Wait()
-		close(fileSizes)
+		<-ctx.Done()
+		stop()
 	}()
 
-	// If the '-v' flag was provided, periodically print the progress stats
-	var tick <-chan time.Time
-	if *vFlag {
-		tick = time.Tick(500 * time.Millisecond)
+	// If the '-verify-du' flag was provided, only compare the totals of the roots with those of the system du
+	if *verifyDUFlag {
+		status := verifyDU(ctx, out, roots)
+		if err := closeOutput(out, outFile); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(status)
 	}
 
-	// Loop that builds up the running file count and size
-	var nfiles, nbytes int64
-loop:
-	for {
-		select {
-		case size, ok := <-fileSizes:
-			if !ok {
-				break loop // fileSizes was closed
+	// If the '-timeout' flag was provided, also stop the walk once the timeout expires
+	walkCtx := ctx
+	if *timeoutFlag > 0 {
+		var cancel context.CancelFunc
+		walkCtx, cancel = context.WithTimeout(ctx, *timeoutFlag)
+		defer cancel()
+	}
+
+	// Walk the directory root(s)
+	res, err := du.WalkContext(walkCtx, roots, opts)
+	if *progressFlag && !*qFlag {
+		fmt.Fprint(os.Stderr, "\r\033[K") // erase the progress line
+	}
+	if rootLines > 0 {
+		fmt.Fprintf(os.Stderr, "\033[%dA\033[J", rootLines) // erase the progress lines of the roots
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+		os.Exit(1)
+	}
+	var other du.Result
+	if *diffFlag != "" {
+		opts.Completed, opts.Checkpoint = nil, nil
+		if other, err = du.WalkContext(walkCtx, []string{*diffFlag}, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			os.Exit(1)
+		}
+		res.Errors = append(res.Errors, other.Errors...)
+	}
+
+	// If the '-resume' flag was provided, remove the checkpoints once the walk is complete
+	if resume != nil {
+		if err := resume.close(!res.Partial && !res.LimitReached); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %s: %v\n", *resumeFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	// If the '-cache' flag was provided, save the totals of the directories for the next run
+	if opts.Cache != nil {
+		if err := saveCache(*cacheFlag, opts.Cache); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %s: %v\n", *cacheFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	// If the '-growth' flag was provided, compare the run with the previous one recorded in the database before recording it
+	if *growthFlag > 0 {
+		if lastRun, err = loadPreviousRun(*dbFlag, res.Roots); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %s: %v\n", *dbFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	// If the '-db' flag was provided, record the run in the database
+	if *dbFlag != "" {
+		if err := saveDB(*dbFlag, res, start); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %s: %v\n", *dbFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	// Final totals unless the '-q' flag was provided, exiting with status 1 if the totals are incomplete because of errors
+	rebaseResult(&res)
+	rebaseResult(&other)
+	if lastRun != nil {
+		lastRun.compare(res)
+	}
+	if *diffFlag == "" {
+		dropEmpty(&res) // -diff compares the empty files too
+	}
+	if !*qFlag {
+		if walkCtx.Err() == context.DeadlineExceeded {
+			fmt.Fprintf(os.Stderr, "du: timed out after %v, the totals are partial\n", *timeoutFlag)
+		}
+		if res.LimitReached || other.LimitReached {
+			fmt.Fprintf(os.Stderr, "du: limit of %d files reached, the totals are partial\n", *maxFilesFlag)
+		}
+		for _, err := range res.Errors {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+		}
+		sort.Slice(fileSums, func(i, j int) bool {
+			return fileSums[i][2*sha256.Size+2:] < fileSums[j][2*sha256.Size+2:]
+		})
+		for _, line := range fileSums {
+			fmt.Fprintln(out, line)
+		}
+		if *diffFlag != "" {
+			printDiff(out, res, other)
+		} else if *filesJSONFlag {
+			// the files were streamed
+		} else if *tuiFlag {
+			if err := runTUI(res); err != nil {
+				fmt.Fprintf(os.Stderr, "du: %v\n", err)
+				os.Exit(1)
 			}
-			nfiles++
-			nbytes += size
-		case <-tick:
-			printProgress(nfiles, nbytes, start)
+		} else if *eventsFlag {
+			printEvent(out, event{Files: res.Files, Bytes: res.Bytes, Done: true, Partial: res.Partial, LimitReached: res.LimitReached, Errors: len(res.Errors)}, start)
+		} else {
+			printDiskUsage(out, res, start)
 		}
 	}
+	if err := closeOutput(out, outFile); err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(exitStatus(res))
+}
 
-	// Final totals
-	printDiskUsage(nfiles, nbytes, start)
+// closeOutput flushes out to f, closing f unless it is stdout.
+func closeOutput(out *bufio.Writer, f *os.File) error {
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if f != os.Stdout {
+		return f.Close()
+	}
+	return nil
 }
 
-// Prints the final summary
-func printDiskUsage(nfiles, nbytes int64, start int64) {
-	stop := time.Now().Unix()
-	elapsed := stop - start
-	if elapsed == 0 {
-		elapsed = 1
+// loadCache returns the cache saved to path, or an empty one if path doesn't exist yet.
+func loadCache(path string) (*du.Cache, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return du.NewCache(), nil
 	}
-	fps := nfiles / elapsed
-	fmt.Printf("\nDone!\nFiles: %d, Size: %.1fGB, Avg FPS: %d, Elapsed: %d seconds\n", nfiles, float64(nbytes)/1e9, fps, elapsed)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return du.LoadCache(bufio.NewReader(f))
 }
 
-// Prints the running progress summary if invoked with -v flag
-func printProgress(nfiles, nbytes int64, start int64) {
-	now := time.Now().Unix()
-	elapsed := now - start
-	if elapsed == 0 {
-		elapsed = 1
+// saveCache saves cache to path, replacing it at once so an interrupted run leaves the previous cache intact.
+func saveCache(path string, cache *du.Cache) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = cache.Save(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
 	}
-	fps := nfiles / elapsed
-	fmt.Printf("Files: %d, Size: %.1fGB, Goroutines: %d, Cur FPS: %d\n", nfiles, float64(nbytes)/1e9, runtime.NumGoroutine(), fps)
+	return os.Rename(tmp, path)
 }
 
-// Recursively walks the file tree rooted at dir and sends the size of each found file on fileSizes channel.
-func walkDir(dir string, n *sync.WaitGroup, fileSizes chan<- int64) {
-	defer n.Done()
-	for _, entry := range dirents(dir) {
-		if entry.IsDir() {
-			n.Add(1)
-			subdir := filepath.Join(dir, entry.Name())
-			go walkDir(subdir, n, fileSizes)
-		} else {
-			fileSizes <- entry.Size()
+// resolveRoots returns the roots with their symbolic links resolved if invoked with -follow-root-symlinks flag, reporting
+// the resolved ones if invoked with -v flag. Roots that can't be resolved are kept as is, their walk reporting the error.
+func resolveRoots(roots []string) []string {
+	resolved := make([]string, len(roots))
+	for i, root := range roots {
+		target, err := filepath.EvalSymlinks(root)
+		if err != nil || target == filepath.Clean(root) {
+			resolved[i] = root
+			continue
 		}
+		if *vFlag && !*qFlag {
+			fmt.Fprintf(os.Stderr, "du: walking %s for %s\n", target, root)
+		}
+		resolved[i] = target
 	}
+	return resolved
 }
 
-// sema is a semaphore for limiting concurrency in dirents to prevent tool many open files situation
-var sema = make(chan struct{}, 256)
+// mergeRoots returns the roots without those that are the same directory as a previous one if invoked with -merge-roots flag,
+// reporting the merged ones if invoked with -v flag. Roots that can't be stated are kept, their walk reporting the error.
+func mergeRoots(roots []string) []string {
+	var merged []string
+	var infos []os.FileInfo
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err == nil {
+			if i := sameDir(infos, info); i >= 0 {
+				if *vFlag && !*qFlag {
+					fmt.Fprintf(os.Stderr, "du: merging %s with %s, the same directory\n", root, merged[i])
+				}
+				continue
+			}
+		}
+		merged = append(merged, root)
+		infos = append(infos, info)
+	}
+	return merged
+}
 
-// dirents returns the entries of directory dir.
-func dirents(dir string) []os.FileInfo {
-	sema <- struct{}{}        // acquire token
-	defer func() { <-sema }() // release token
+// sameDir returns the index of the file information in infos describing the same file as info, or -1.
+func sameDir(infos []os.FileInfo, info os.FileInfo) int {
+	for i, other := range infos {
+		if other != nil && os.SameFile(other, info) {
+			return i
+		}
+	}
+	return -1
+}
 
-	entries, err := ioutil.ReadDir(dir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "du: %v\n", err)
-		return nil
+// exitStatus returns the exit status for the totals in res: 1 if they are incomplete because of errors
+// unless invoked with -ignore-errors flag, 0 otherwise
+func exitStatus(res du.Result) int {
+	if len(res.Errors) > 0 && !*ignoreErrorsFlag {
+		return 1
 	}
-	return entries
+	return 0
 }
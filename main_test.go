@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestPermValue(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		mode fs.FileMode
+		any  bool
+	}{
+		{"0002", 0o002, false},
+		{"-0002", 0o002, false},
+		{"755", 0o755, false},
+		{"4755", fs.ModeSetuid | 0o755, false},
+		{"2000", fs.ModeSetgid, false},
+		{"1000", fs.ModeSticky, false},
+		{"7777", fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky | 0o777, false},
+		{"/6000", fs.ModeSetuid | fs.ModeSetgid, true},
+		{"/0111", 0o111, true},
+		{"o+w", 0o002, false},
+		{"u+rw", 0o600, false},
+		{"g+x,o+r", 0o014, false},
+		{"ug+w", 0o220, false},
+		{"a+x", 0o111, false},
+		{"+r", 0o444, false},
+		{"u+s,g+s", fs.ModeSetuid | fs.ModeSetgid, false},
+		{"u+s", fs.ModeSetuid, false},
+		{"g+s", fs.ModeSetgid, false},
+		{"o+s", 0, false}, // no setuid bit for the others, like chmod
+		{"+t", fs.ModeSticky, false},
+		{"o+t", fs.ModeSticky, false},
+		{"a+st", fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky, false},
+		{"/o+w,g+w", 0o022, true},
+	} {
+		var v permValue
+		if err := v.Set(tt.s); err != nil || v.mode != tt.mode || v.any != tt.any {
+			t.Errorf("%q: got %v, any %v, error %v, want %v, any %v", tt.s, v.mode, v.any, err, tt.mode, tt.any)
+		}
+	}
+	for _, s := range []string{"", "/", "10000", "8", "o=w", "o-w", "x+w", "u+q", "u+", "u+w,", "0o7"} {
+		var v permValue
+		if err := v.Set(s); err == nil {
+			t.Errorf("%q: got %v, want an error", s, v.mode)
+		}
+	}
+}
+
+func TestPermValueReset(t *testing.T) {
+	var v permValue
+	if err := v.Set("/0002"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set("0004"); err != nil || v.any || v.mode != 0o004 {
+		t.Errorf("got %v, any %v, error %v, want all of 0004", v.mode, v.any, err)
+	}
+}
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// report is the final summary printed if invoked with -json flag.
+type report struct {
+	Files          int64          `json:"files"`
+	Directories    int64          `json:"directories"`
+	Bytes          int64          `json:"bytes"`
+	SizeMode       string         `json:"size_mode"`
+	ElapsedSeconds float64        `json:"elapsed_seconds"`
+	AvgFPS         float64        `json:"avg_fps"`
+	Partial        bool           `json:"partial"`
+	LimitReached   bool           `json:"limit_reached"`
+	Roots          []string       `json:"roots"`
+	PerRoot        []dirReport    `json:"per_root,omitempty"`
+	Dirs           []dirReport    `json:"dirs,omitempty"`
+	OtherDirs      int64          `json:"other_dirs,omitempty"`
+	OtherBytes     int64          `json:"other_bytes,omitempty"`
+	TopFiles       []fileReport   `json:"top_files,omitempty"`
+	TopDirs        []fileReport   `json:"top_dirs,omitempty"`
+	SparseFiles    []sparseReport `json:"sparse_files,omitempty"`
+	SparseBytes    int64          `json:"sparse_bytes,omitempty"`
+	SlackBytes     int64          `json:"slack_bytes,omitempty"`
+	Exts           []extReport    `json:"extensions,omitempty"`
+	Owners         []ownerReport  `json:"owners,omitempty"`
+	Types          []typeReport   `json:"types,omitempty"`
+	FileSystems    []fsReport     `json:"file_systems,omitempty"`
+	FSTypes        []fsTypeReport `json:"fs_types,omitempty"`
+	Ages           []ageReport    `json:"ages,omitempty"`
+	Depths         []depthReport  `json:"depths,omitempty"`
+	Sizes          []sizeReport   `json:"sizes,omitempty"`
+	EmptyDirs      []string       `json:"empty_dirs,omitempty"`
+	EmptyFiles     []string       `json:"empty_files,omitempty"`
+	LongPaths      []longPath     `json:"long_paths,omitempty"`
+	Dupes          []dupeReport   `json:"dupes,omitempty"`
+	Checksum       string         `json:"checksum,omitempty"`
+	Growth         *growthReport  `json:"growth,omitempty"`
+	MeanBytes      int64          `json:"mean_bytes,omitempty"`
+	MedianBytes    int64          `json:"median_bytes,omitempty"`
+	SampledFiles   int64          `json:"sampled_files,omitempty"`
+	MarginBytes    *int64         `json:"margin_bytes,omitempty"`
+	Errors         []errorReport  `json:"errors"`
+	ExitStatus     int            `json:"exit_status"`
+}
+
+// event is a JSON line printed if invoked with -events flag, at each progress update and once done.
+type event struct {
+	TS             string  `json:"ts"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Files          int64   `json:"files"`
+	Bytes          int64   `json:"bytes"`
+	Goroutines     int     `json:"goroutines"`
+	FPS            float64 `json:"fps"`
+	Done           bool    `json:"done,omitempty"`
+	Partial        bool    `json:"partial,omitempty"`
+	LimitReached   bool    `json:"limit_reached,omitempty"`
+	Errors         int     `json:"errors,omitempty"`
+}
+
+// dirReport is the JSON form of a directory subtree total.
+type dirReport struct {
+	Path     string `json:"path"`
+	Bytes    int64  `json:"bytes"`
+	Files    int64  `json:"files"`
+	Dirs     int64  `json:"dirs"`
+	Entries  int64  `json:"entries"`
+	Symlinks int64  `json:"symlinks"`
+}
+
+// fileReport is the JSON form of a file reported by -top, or of a directory reported by -biggest.
+type fileReport struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// longPath is the JSON form of a path reported by -long-paths, with its length in characters.
+type longPath struct {
+	Path   string `json:"path"`
+	Length int    `json:"length"`
+}
+
+// sparseReport is the JSON form of a file reported by -sparse.
+type sparseReport struct {
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	Allocated int64  `json:"allocated"`
+}
+
+// extReport is the JSON form of a file extension total.
+type extReport struct {
+	Ext   string `json:"ext"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+}
+
+// sizeReport is the JSON form of a size range total.
+type sizeReport struct {
+	Range string `json:"range"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+}
+
+// ownerReport is the JSON form of a file owner total.
+type ownerReport struct {
+	Owner string `json:"owner"`
+	UID   uint32 `json:"uid"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+}
+
+// typeReport is the JSON form of a file type total.
+type typeReport struct {
+	Type    string `json:"type"`
+	Bytes   int64  `json:"bytes"`
+	Entries int64  `json:"entries"`
+}
+
+// fileRecord is the JSON form of a file, printed as a line if invoked with -files-json flag.
+type fileRecord struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	MTime string `json:"mtime"`
+	Mode  string `json:"mode"`
+}
+
+// fsReport is the JSON form of a mounted file system total.
+type fsReport struct {
+	Mount  string `json:"mount"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	Bytes  int64  `json:"bytes"`
+	Files  int64  `json:"files"`
+}
+
+// fsTypeReport is the JSON form of a file system type total.
+type fsTypeReport struct {
+	Type  string `json:"type"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+}
+
+// ageReport is the JSON form of an age bucket total.
+type ageReport struct {
+	Age   string `json:"age"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+}
+
+// depthReport is the JSON form of a depth total.
+type depthReport struct {
+	Depth int   `json:"depth"`
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+	Dirs  int64 `json:"dirs"`
+}
+
+// dupeReport is the JSON form of a group of duplicate files.
+type dupeReport struct {
+	Bytes       int64    `json:"bytes"`
+	Reclaimable int64    `json:"reclaimable"`
+	Paths       []string `json:"paths"`
+}
+
+// errorReport is the JSON form of an entry that couldn't be read.
+type errorReport struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// dirOrders maps the orders accepted by the -sort flag to a function reporting whether the totals of
+// directory a sort before those of b, or to nil for the orders by name.
+var dirOrders = map[string]func(a, b *du.Usage) bool{
+	"size":     func(a, b *du.Usage) bool { return a.Bytes > b.Bytes },
+	"-size":    func(a, b *du.Usage) bool { return a.Bytes < b.Bytes },
+	"files":    func(a, b *du.Usage) bool { return a.Files > b.Files },
+	"-files":   func(a, b *du.Usage) bool { return a.Files < b.Files },
+	"entries":  func(a, b *du.Usage) bool { return a.Files+a.Dirs > b.Files+b.Dirs },
+	"-entries": func(a, b *du.Usage) bool { return a.Files+a.Dirs < b.Files+b.Dirs },
+	"name":     nil,
+	"-name":    nil,
+}
+
+// sortValue is a flag holding one of the dirOrders.
+type sortValue string
+
+func (v *sortValue) String() string {
+	return string(*v)
+}
+
+func (v *sortValue) Set(order string) error {
+	if _, ok := dirOrders[order]; !ok {
+		return fmt.Errorf("unknown order %q", order)
+	}
+	*v = sortValue(order)
+	return nil
+}
+
+// sampleMargin returns the margin of error of the size estimated with -sample, or "unknown" if too few files were sampled.
+func sampleMargin(res du.Result) string {
+	if res.SampleMargin < 0 {
+		return "unknown"
+	}
+	return formatSize(res.SampleMargin)
+}
+
+// dropEmpty removes the directories, files and groups of files of 0 bytes from the reports of res if invoked with
+// -exclude-empty flag, after they're counted and recorded with -db.
+func dropEmpty(res *du.Result) {
+	if !*excludeEmptyFlag {
+		return
+	}
+	for _, totals := range []map[string]*du.Usage{res.Dirs, res.Exts} {
+		for key, u := range totals {
+			if u.Bytes == 0 {
+				delete(totals, key)
+			}
+		}
+	}
+	for uid, u := range res.Owners {
+		if u.Bytes == 0 {
+			delete(res.Owners, uid)
+		}
+	}
+	for path, size := range res.FileSizes {
+		if size == 0 {
+			delete(res.FileSizes, path)
+		}
+	}
+	res.TopFiles, res.TopDirs = nonEmpty(res.TopFiles), nonEmpty(res.TopDirs)
+}
+
+// nonEmpty returns the files of files larger than 0 bytes.
+func nonEmpty(files []du.File) []du.File {
+	var kept []du.File
+	for _, f := range files {
+		if f.Size > 0 {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// reportedDirs returns the paths of dirs within the -threshold and -min-files limits, in the -sort order.
+// Directories that are equal in that order are sorted by path.
+func reportedDirs(dirs map[string]*du.Usage) []string {
+	paths := make([]string, 0, len(dirs))
+	for path, u := range dirs {
+		entries := u.Entries
+		if *minFilesSubtreeFlag {
+			entries = u.Files + u.Dirs
+		}
+		if entries < *minFilesFlag {
+			continue
+		}
+		if (thresholdFlag >= 0 && u.Bytes >= int64(thresholdFlag)) || (thresholdFlag < 0 && u.Bytes <= -int64(thresholdFlag)) {
+			paths = append(paths, path)
+		}
+	}
+	if sortFlag == "-name" {
+		sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	} else {
+		sort.Strings(paths)
+	}
+	if before := dirOrders[string(sortFlag)]; before != nil {
+		sort.SliceStable(paths, func(i, j int) bool {
+			return before(dirs[paths[i]], dirs[paths[j]])
+		})
+	}
+	return paths
+}
+
+// counts returns the numbers of files, directories and symbolic links of a directory subtree as columns
+// of fixed width, to line up the paths after them.
+func counts(u *du.Usage) string {
+	return fmt.Sprintf("%10d files\t%8d dirs\t%8d symlinks", u.Files, u.Dirs, u.Symlinks)
+}
+
+// dirTotal returns the size of a directory subtree colored by coloredSize, or its number of entries if invoked with -inodes or -count-only flags.
+func dirTotal(u *du.Usage) string {
+	if *inodesFlag || *countOnlyFlag {
+		return strconv.FormatInt(u.Files+u.Dirs, 10)
+	}
+	return coloredSize(u.Bytes)
+}
+
+// sortedBySize returns the keys of usages sorted by size, largest first, and then by name.
+func sortedBySize(usages map[string]*du.Usage) []string {
+	keys := make([]string, 0, len(usages))
+	for key := range usages {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if usages[keys[i]].Bytes != usages[keys[j]].Bytes {
+			return usages[keys[i]].Bytes > usages[keys[j]].Bytes
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// sortedOwners returns the user ids of owners sorted by size, largest first, and then by id.
+func sortedOwners(owners map[uint32]*du.Usage) []uint32 {
+	uids := make([]uint32, 0, len(owners))
+	for uid := range owners {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		if owners[uids[i]].Bytes != owners[uids[j]].Bytes {
+			return owners[uids[i]].Bytes > owners[uids[j]].Bytes
+		}
+		return uids[i] < uids[j]
+	})
+	return uids
+}
+
+// fsTotals returns the totals of the file systems holding the files of res, largest first, and of each file system type,
+// reporting on stderr if the mounted file systems can't be listed, in which case the devices are all of unknown type.
+func fsTotals(res du.Result) (mounts []fsReport, types map[string]*du.Usage) {
+	list, err := du.Mounts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+	}
+	byDev := make(map[uint64]du.Mount)
+	for _, m := range list {
+		if _, ok := byDev[m.Dev]; !ok {
+			byDev[m.Dev] = m // the first mount of a device rather than its bind mounts
+		}
+	}
+	types = make(map[string]*du.Usage)
+	for dev, u := range res.Devices {
+		m, ok := byDev[dev]
+		if !ok {
+			m = du.Mount{Path: fmt.Sprintf("device %d", dev), Type: "unknown"}
+		}
+		mounts = append(mounts, fsReport{Mount: m.Path, Type: m.Type, Source: m.Source, Bytes: u.Bytes, Files: u.Files})
+		t := types[m.Type]
+		if t == nil {
+			t = &du.Usage{}
+			types[m.Type] = t
+		}
+		t.Bytes += u.Bytes
+		t.Files += u.Files
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		if mounts[i].Bytes != mounts[j].Bytes {
+			return mounts[i].Bytes > mounts[j].Bytes
+		}
+		return mounts[i].Mount < mounts[j].Mount
+	})
+	return mounts, types
+}
+
+// userNames caches the user names looked up by userName.
+var userNames = make(map[uint32]string)
+
+// userName returns the name of the user with id uid, or the numeric id if it has no passwd entry.
+func userName(uid uint32) string {
+	if name, ok := userNames[uid]; ok {
+		return name
+	}
+	id := strconv.FormatUint(uint64(uid), 10)
+	name := id
+	if u, err := user.LookupId(id); err == nil {
+		name = u.Username
+	}
+	userNames[uid] = name
+	return name
+}
+
+// Prints the final summary, with the reports requested by the flags
+func printDiskUsage(w io.Writer, res du.Result, start time.Time) {
+	elapsed := time.Since(start)
+	if *noTimingFlag {
+		elapsed = 0
+	}
+	fps := filesPerSecond(res.Files, elapsed)
+	if *csvFlag {
+		printCSV(w, res)
+		return
+	}
+	if *promFlag {
+		printProm(w, res, elapsed)
+		return
+	}
+	if *jsonFlag || *ndjsonFlag {
+		printJSON(w, report{Files: res.Files, Directories: res.Directories, Bytes: res.Bytes, SizeMode: sizeMode(res), ElapsedSeconds: elapsed.Round(time.Millisecond).Seconds(), AvgFPS: fps, Partial: res.Partial, LimitReached: res.LimitReached, Roots: res.Roots}, res)
+		return
+	}
+	if *print0Flag {
+		printPaths0(w, res)
+		return
+	}
+	if *treeFlag {
+		printTree(w, res)
+	} else {
+		for _, path := range reportedDirs(res.Dirs) {
+			if *countsFlag {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", dirTotal(res.Dirs[path]), counts(res.Dirs[path]), path)
+			} else {
+				fmt.Fprintf(w, "%s\t%s\n", dirTotal(res.Dirs[path]), path)
+			}
+		}
+		if res.OtherDirs > 0 {
+			fmt.Fprintf(w, "...and %d smaller directories: %s\n", res.OtherDirs, coloredSize(res.OtherBytes))
+		}
+	}
+	if *sFlag {
+		for _, root := range res.Roots {
+			if *countsFlag {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", coloredSize(res.PerRoot[root].Bytes), counts(res.PerRoot[root]), root)
+			} else {
+				fmt.Fprintf(w, "%s\t%d files\t%d dirs\t%s\n", coloredSize(res.PerRoot[root].Bytes), res.PerRoot[root].Files, res.PerRoot[root].Dirs, root)
+			}
+		}
+	}
+	if *totalFlag {
+		fmt.Fprintf(w, "%s\t%d files\t%d dirs\ttotal\n", coloredSize(res.Bytes), res.Files, res.Directories)
+	}
+	status := "Done!"
+	if res.LimitReached {
+		status = "Limit reached! Partial totals:"
+	} else if res.Partial {
+		status = "Interrupted! Partial totals:"
+	}
+	switch {
+	case *countOnlyFlag && *noTimingFlag:
+		fmt.Fprintf(w, "\n%s\nFiles: %d, Directories: %d\n", status, res.Files, res.Directories)
+	case *countOnlyFlag:
+		fmt.Fprintf(w, "\n%s\nFiles: %d, Directories: %d, Avg FPS: %.1f, Elapsed: %.3f seconds\n", status, res.Files, res.Directories, fps, elapsed.Seconds())
+	case *noTimingFlag:
+		fmt.Fprintf(w, "\n%s\nFiles: %d, Size (%s): %s\n", status, res.Files, sizeMode(res), formatSize(res.Bytes))
+	default:
+		fmt.Fprintf(w, "\n%s\nFiles: %d, Size (%s): %s, Avg FPS: %.1f, Elapsed: %.3f seconds\n", status, res.Files, sizeMode(res), formatSize(res.Bytes), fps, elapsed.Seconds())
+	}
+	if *checksumFlag {
+		fmt.Fprintf(w, "Checksum: %x\n", res.Checksum)
+	}
+	if *sampleFlag > 0 {
+		fmt.Fprintf(w, "Estimated from %d sampled files: %s ± %s at 95%% confidence\n", res.SampledFiles, formatSize(res.Bytes), sampleMargin(res))
+	}
+	if *vFlag && res.TunedReads > 0 {
+		fmt.Fprintf(w, "Autotune: %d directories read at once\n", res.TunedReads)
+	}
+	if *fileStatsFlag {
+		fmt.Fprintf(w, "Mean file size: %s, Median: ~%s\n", formatSize(meanSize(res)), formatSize(res.MedianSize))
+	}
+	if *inodesFlag {
+		fmt.Fprintf(w, "Entries: %d (%d files, %d directories)\n", res.Files+res.Directories, res.Files, res.Directories)
+	}
+	if len(res.Errors) > 0 {
+		fmt.Fprintf(w, "Errors: %d entries unreadable\n", len(res.Errors))
+	}
+	if len(res.TopFiles) > 0 {
+		fmt.Fprintf(w, "\nLargest files:\n")
+		for _, f := range res.TopFiles {
+			fmt.Fprintf(w, "%s\t%s\n", coloredSize(f.Size), f.Path)
+		}
+	}
+	if len(res.TopDirs) > 0 {
+		fmt.Fprintf(w, "\nLargest directories:\n")
+		for _, d := range res.TopDirs {
+			fmt.Fprintf(w, "%s\t%s\n", coloredSize(d.Size), d.Path)
+		}
+	}
+	if *sparseFlag > 0 {
+		fmt.Fprintf(w, "\nSparse files:\n")
+		for _, f := range res.SparseFiles {
+			fmt.Fprintf(w, "%s apparent\t%s allocated\t%s\n", formatSize(f.Apparent), formatSize(f.Allocated), f.Path)
+		}
+		fmt.Fprintf(w, "Sparse: %s unallocated, Slack: %s allocated beyond the file sizes\n", formatSize(res.SparseBytes), formatSize(res.SlackBytes))
+	}
+	if len(res.Ages) > 0 {
+		fmt.Fprintf(w, "\nAges:\n")
+		for i, b := range res.Ages {
+			fmt.Fprintf(w, "%s\t%d files\t%s\n", coloredSize(b.Bytes), b.Files, ageLabel(res.Ages, i))
+		}
+	}
+	if len(res.Depths) > 0 {
+		fmt.Fprintf(w, "\nDepths:\n")
+		for depth, u := range res.Depths {
+			fmt.Fprintf(w, "%s\t%d files\t%d dirs\tdepth %d\n", coloredSize(u.Bytes), u.Files, u.Dirs, depth)
+		}
+	}
+	if len(res.Sizes) > 0 {
+		fmt.Fprintf(w, "\nSizes:\n")
+		printHistogram(w, res.Sizes)
+	}
+	if len(res.Exts) > 0 {
+		fmt.Fprintf(w, "\nExtensions:\n")
+		for _, ext := range sortedBySize(res.Exts) {
+			fmt.Fprintf(w, "%s\t%d files\t%s\n", coloredSize(res.Exts[ext].Bytes), res.Exts[ext].Files, ext)
+		}
+	}
+	if len(res.Owners) > 0 {
+		fmt.Fprintf(w, "\nOwners:\n")
+		for _, uid := range sortedOwners(res.Owners) {
+			fmt.Fprintf(w, "%s\t%d files\t%s\n", coloredSize(res.Owners[uid].Bytes), res.Owners[uid].Files, userName(uid))
+		}
+	}
+	if res.Devices != nil {
+		mounts, types := fsTotals(res)
+		fmt.Fprintf(w, "\nFile systems:\n")
+		for _, m := range mounts {
+			fmt.Fprintf(w, "%s\t%d files\t%s\t%s\n", coloredSize(m.Bytes), m.Files, m.Type, m.Mount)
+		}
+		fmt.Fprintf(w, "\nFile system types:\n")
+		for _, typ := range sortedBySize(types) {
+			fmt.Fprintf(w, "%s\t%d files\t%s\n", coloredSize(types[typ].Bytes), types[typ].Files, typ)
+		}
+	}
+	if len(res.Types) > 0 {
+		fmt.Fprintf(w, "\nTypes:\n")
+		for _, typ := range sortedBySize(res.Types) {
+			fmt.Fprintf(w, "%s\t%d entries\t%s\n", coloredSize(res.Types[typ].Bytes), res.Types[typ].Files+res.Types[typ].Dirs, typ)
+		}
+	}
+	if len(res.EmptyDirs) > 0 {
+		fmt.Fprintf(w, "\nEmpty directories:\n")
+		for _, path := range res.EmptyDirs {
+			fmt.Fprintf(w, "%s\n", path)
+		}
+	}
+	if len(res.EmptyFiles) > 0 {
+		fmt.Fprintf(w, "\nEmpty files:\n")
+		for _, path := range res.EmptyFiles {
+			fmt.Fprintf(w, "%s\n", path)
+		}
+	}
+	if len(res.LongPaths) > 0 {
+		fmt.Fprintf(w, "\nPaths longer than %d characters:\n", *longPathsFlag)
+		for _, path := range res.LongPaths {
+			fmt.Fprintf(w, "%6d\t%s\n", utf8.RuneCountInString(path), path)
+		}
+	}
+	if len(res.Dupes) > 0 {
+		var reclaimable int64
+		fmt.Fprintf(w, "\nDuplicate files:\n")
+		for _, g := range res.Dupes {
+			fmt.Fprintf(w, "%s reclaimable, %d copies of %s:\n", formatSize(g.Reclaimable()), len(g.Paths), formatSize(g.Size))
+			for _, path := range g.Paths {
+				fmt.Fprintf(w, "\t%s\n", path)
+			}
+			reclaimable += g.Reclaimable()
+		}
+		fmt.Fprintf(w, "Reclaimable: %s in %d groups\n", formatSize(reclaimable), len(res.Dupes))
+	}
+	if *growthFlag > 0 {
+		printGrowth(w, lastRun)
+	}
+}
+
+// Prints only the paths of the entries listed in the summary, each ending with a NUL character for xargs -0
+func printPaths0(w io.Writer, res du.Result) {
+	var paths []string
+	paths = append(paths, reportedDirs(res.Dirs)...)
+	for _, f := range res.TopFiles {
+		paths = append(paths, f.Path)
+	}
+	for _, d := range res.TopDirs {
+		paths = append(paths, d.Path)
+	}
+	paths = append(paths, res.EmptyDirs...)
+	paths = append(paths, res.EmptyFiles...)
+	paths = append(paths, res.LongPaths...)
+	for _, g := range res.Dupes {
+		paths = append(paths, g.Paths...)
+	}
+	for _, path := range paths {
+		fmt.Fprintf(w, "%s\x00", path)
+	}
+}
+
+// Prints the final summary as a JSON object, on a single line if invoked with -ndjson flag
+func printJSON(w io.Writer, rep report, res du.Result) {
+	rep.Errors = []errorReport{}
+	for _, e := range res.Errors {
+		msg := e.Err
+		var pe *fs.PathError
+		if errors.As(e.Err, &pe) {
+			msg = pe.Err // the path is reported on its own
+		}
+		rep.Errors = append(rep.Errors, errorReport{Path: e.Path, Error: msg.Error()})
+	}
+	rep.ExitStatus = exitStatus(res)
+	if *sFlag {
+		for _, root := range res.Roots {
+			rep.PerRoot = append(rep.PerRoot, dirReport{Path: root, Bytes: res.PerRoot[root].Bytes, Files: res.PerRoot[root].Files, Dirs: res.PerRoot[root].Dirs, Symlinks: res.PerRoot[root].Symlinks})
+		}
+	}
+	for _, path := range reportedDirs(res.Dirs) {
+		rep.Dirs = append(rep.Dirs, dirReport{Path: path, Bytes: res.Dirs[path].Bytes, Files: res.Dirs[path].Files, Dirs: res.Dirs[path].Dirs, Entries: res.Dirs[path].Entries, Symlinks: res.Dirs[path].Symlinks})
+	}
+	rep.OtherDirs, rep.OtherBytes = res.OtherDirs, res.OtherBytes
+	for _, f := range res.TopFiles {
+		rep.TopFiles = append(rep.TopFiles, fileReport{Path: f.Path, Bytes: f.Size})
+	}
+	for _, d := range res.TopDirs {
+		rep.TopDirs = append(rep.TopDirs, fileReport{Path: d.Path, Bytes: d.Size})
+	}
+	for _, f := range res.SparseFiles {
+		rep.SparseFiles = append(rep.SparseFiles, sparseReport{Path: f.Path, Bytes: f.Apparent, Allocated: f.Allocated})
+	}
+	rep.SparseBytes, rep.SlackBytes = res.SparseBytes, res.SlackBytes
+	for i, b := range res.Ages {
+		rep.Ages = append(rep.Ages, ageReport{Age: ageLabel(res.Ages, i), Bytes: b.Bytes, Files: b.Files})
+	}
+	for depth, u := range res.Depths {
+		rep.Depths = append(rep.Depths, depthReport{Depth: depth, Bytes: u.Bytes, Files: u.Files, Dirs: u.Dirs})
+	}
+	for i, b := range res.Sizes {
+		rep.Sizes = append(rep.Sizes, sizeReport{Range: sizeLabel(res.Sizes, i), Bytes: b.Bytes, Files: b.Files})
+	}
+	for _, ext := range sortedBySize(res.Exts) {
+		rep.Exts = append(rep.Exts, extReport{Ext: ext, Bytes: res.Exts[ext].Bytes, Files: res.Exts[ext].Files})
+	}
+	for _, uid := range sortedOwners(res.Owners) {
+		rep.Owners = append(rep.Owners, ownerReport{Owner: userName(uid), UID: uid, Bytes: res.Owners[uid].Bytes, Files: res.Owners[uid].Files})
+	}
+	for _, typ := range sortedBySize(res.Types) {
+		rep.Types = append(rep.Types, typeReport{Type: typ, Bytes: res.Types[typ].Bytes, Entries: res.Types[typ].Files + res.Types[typ].Dirs})
+	}
+	if res.Devices != nil {
+		var types map[string]*du.Usage
+		rep.FileSystems, types = fsTotals(res)
+		for _, typ := range sortedBySize(types) {
+			rep.FSTypes = append(rep.FSTypes, fsTypeReport{Type: typ, Bytes: types[typ].Bytes, Files: types[typ].Files})
+		}
+	}
+	rep.EmptyDirs = res.EmptyDirs
+	rep.EmptyFiles = res.EmptyFiles
+	for _, path := range res.LongPaths {
+		rep.LongPaths = append(rep.LongPaths, longPath{Path: path, Length: utf8.RuneCountInString(path)})
+	}
+	for _, g := range res.Dupes {
+		rep.Dupes = append(rep.Dupes, dupeReport{Bytes: g.Size, Reclaimable: g.Reclaimable(), Paths: g.Paths})
+	}
+	if *fileStatsFlag {
+		rep.MeanBytes, rep.MedianBytes = meanSize(res), res.MedianSize
+	}
+	if *sampleFlag > 0 {
+		rep.SampledFiles = res.SampledFiles
+		if res.SampleMargin >= 0 {
+			rep.MarginBytes = &res.SampleMargin // left out if unknown
+		}
+	}
+	if *growthFlag > 0 {
+		rep.Growth = growthJSON(lastRun)
+	}
+	if *checksumFlag {
+		rep.Checksum = hex.EncodeToString(res.Checksum[:])
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if !*ndjsonFlag {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(rep); err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+	}
+}
+
+// Prints the directory totals in the -sort order as CSV rows of path, bytes and files, after a header row unless invoked with -no-header flag
+func printCSV(w io.Writer, res du.Result) {
+	cw := csv.NewWriter(w)
+	if !*noHeaderFlag {
+		size := "bytes"
+		if unitFlag.size > 0 {
+			size = unitFlag.header()
+		}
+		header := []string{"path", size, "files"}
+		if *countsFlag {
+			header = append(header, "dirs", "symlinks")
+		}
+		cw.Write(header)
+	}
+	for _, path := range reportedDirs(res.Dirs) {
+		size := strconv.FormatInt(res.Dirs[path].Bytes, 10)
+		if unitFlag.size > 0 {
+			size = strconv.FormatInt(unitFlag.blocks(res.Dirs[path].Bytes), 10)
+		}
+		row := []string{path, size, strconv.FormatInt(res.Dirs[path].Files, 10)}
+		if *countsFlag {
+			row = append(row, strconv.FormatInt(res.Dirs[path].Dirs, 10), strconv.FormatInt(res.Dirs[path].Symlinks, 10))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+	}
+}
+
+// meanSize returns the mean size of the files counted in res, rounded to the nearest byte, or 0 if there are none.
+func meanSize(res du.Result) int64 {
+	if res.Files == 0 {
+		return 0
+	}
+	return (res.Bytes + res.Files/2) / res.Files
+}
+
+// sizeMode returns which sizes res holds: "apparent" file sizes or "on-disk" allocated disk space.
+func sizeMode(res du.Result) string {
+	if res.Apparent {
+		return "apparent"
+	}
+	return "on-disk"
+}
+
+// ageLabel returns the age range of the i-th bucket of ages, e.g. "<30d" or ">=365d" for the last one.
+func ageLabel(ages []du.AgeBucket, i int) string {
+	if i == len(ages)-1 {
+		return ">=" + formatAge(ages[i-1].Max)
+	}
+	return "<" + formatAge(ages[i].Max)
+}
+
+// sizeLabel returns the size range of the i-th bucket of sizes, e.g. "1K-4K" or ">=1G" for the last one.
+func sizeLabel(sizes []du.SizeBucket, i int) string {
+	switch i {
+	case len(sizes) - 1:
+		return ">=" + formatEdge(sizes[i-1].Max)
+	case 0:
+		return "0-" + formatEdge(sizes[i].Max)
+	}
+	return formatEdge(sizes[i-1].Max) + "-" + formatEdge(sizes[i].Max)
+}
+
+// histWidth is the width of the longest bar printed by printHistogram.
+const histWidth = 40
+
+// Prints the size range totals as a bar chart of the number of files in each range
+func printHistogram(w io.Writer, sizes []du.SizeBucket) {
+	var most int64
+	for _, b := range sizes {
+		if b.Files > most {
+			most = b.Files
+		}
+	}
+	for i, b := range sizes {
+		bar := 0
+		if most > 0 {
+			bar = int((b.Files*histWidth + most - 1) / most)
+		}
+		fmt.Fprintf(w, "%-10s %10d files %10s  %s\n", sizeLabel(sizes, i), b.Files, formatSize(b.Bytes), strings.Repeat("#", bar))
+	}
+}
+
+// Prints the running progress summary if invoked with -v flag
+func printProgress(nfiles, nbytes int64, start time.Time, eta string) {
+	fps := filesPerSecond(nfiles, time.Since(start))
+	fmt.Fprintf(os.Stderr, "Files: %d, Size: %s, Goroutines: %d, Cur FPS: %.1f, ETA: %s\n", nfiles, formatSize(nbytes), runtime.NumGoroutine(), fps, eta)
+}
+
+// etaEstimate estimates the time left to walk the tree from the trend of the directories found and read.
+// The directories found but not read yet are the known work left; the estimate assumes the directories
+// keep being found and read at their recent rates, so it only exists once they're read faster than found.
+type etaEstimate struct {
+	last                time.Time
+	found, read         int64
+	foundRate, readRate float64 // directories per second, smoothed over the recent updates
+}
+
+// update records the directory counts at now and returns the estimated time left, or "unknown" if the
+// directories are still found as fast as they're read.
+func (e *etaEstimate) update(found, read int64, now time.Time) string {
+	if !e.last.IsZero() {
+		if dt := now.Sub(e.last).Seconds(); dt > 0 {
+			e.foundRate = smoothRate(e.foundRate, float64(found-e.found)/dt)
+			e.readRate = smoothRate(e.readRate, float64(read-e.read)/dt)
+		}
+	}
+	e.last, e.found, e.read = now, found, read
+	left, net := found-read, e.readRate-e.foundRate
+	if left <= 0 {
+		return "0s"
+	}
+	if net <= 0 {
+		return "unknown"
+	}
+	return "~" + time.Duration(float64(left)/net*float64(time.Second)).Round(time.Second).String()
+}
+
+// smoothRate returns the exponential moving average of the rates given prev and the latest rate.
+func smoothRate(prev, rate float64) float64 {
+	const weight = 0.3 // of the latest rate
+	return prev + weight*(rate-prev)
+}
+
+// filesPerSecond returns the average number of files counted per second over elapsed, or 0 if no time elapsed.
+func filesPerSecond(files int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(files) / elapsed.Seconds()
+}
+
+// Prints ev as a JSON line, setting its timestamp, the elapsed time since start and the files per second
+func printEvent(w io.Writer, ev event, start time.Time) {
+	now := time.Now()
+	elapsed := now.Sub(start)
+	ev.TS = now.Format(time.RFC3339Nano)
+	ev.ElapsedSeconds = elapsed.Round(time.Millisecond).Seconds()
+	ev.Goroutines = runtime.NumGoroutine()
+	ev.FPS = filesPerSecond(ev.Files, elapsed)
+	if err := json.NewEncoder(w).Encode(ev); err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+	}
+}
+
+// Prints the running progress summary of each root on its own line over the previous lines if invoked with -root-progress flag,
+// the current FPS of each root counting the files since its totals of the previous update, an interval ago
+func printRootProgress(roots []string, totals, last []du.Usage, interval time.Duration, lines int) {
+	if lines > 0 {
+		fmt.Fprintf(os.Stderr, "\033[%dA", lines) // back to the first line
+	}
+	for i, root := range roots {
+		var fps float64
+		if last != nil {
+			fps = filesPerSecond(totals[i].Files-last[i].Files, interval)
+		}
+		fmt.Fprintf(os.Stderr, "\rFiles: %d, Size: %s, Cur FPS: %.1f\t%s\033[K\n", totals[i].Files, formatSize(totals[i].Bytes), fps, root)
+	}
+}
+
+// spinner holds the frames of the spinner shown by printProgressLine.
+const spinner = `|/-\`
+
+// Prints the running progress summary over the previous one if invoked with -progress flag, spins counting the updates so far
+func printProgressLine(nfiles, nbytes int64, start time.Time, spins int) {
+	elapsed := time.Since(start)
+	fmt.Fprintf(os.Stderr, "\r%c Files: %d, Size: %s, FPS: %.1f, Elapsed: %.1f seconds\033[K", spinner[spins%len(spinner)], nfiles, formatSize(nbytes), filesPerSecond(nfiles, elapsed), elapsed.Seconds())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+func TestPrintCSV(t *testing.T) {
+	res := du.Result{Dirs: map[string]*du.Usage{
+		"root":                 {Bytes: 600, Files: 6},
+		"root/a,b":             {Bytes: 500, Files: 5},
+		`root/say "hi"`:        {Bytes: 400, Files: 4},
+		"root/new\nline":       {Bytes: 300, Files: 3},
+		` root/"quoted, too"`:  {Bytes: 200, Files: 2},
+		"root/tab\tand\x1fsep": {Bytes: 100, Files: 1},
+	}}
+	var buf bytes.Buffer
+	printCSV(&buf, res)
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("%v in:\n%s", err, buf.String())
+	}
+	want := [][]string{
+		{"path", "bytes", "files"},
+		{"root", "600", "6"},
+		{"root/a,b", "500", "5"},
+		{`root/say "hi"`, "400", "4"},
+		{"root/new\nline", "300", "3"},
+		{` root/"quoted, too"`, "200", "2"},
+		{"root/tab\tand\x1fsep", "100", "1"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %q, want %q", rows, want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// absRoots returns the roots made absolute if invoked with -abspath or -relative flag, so that the
+// reported paths don't depend on how the roots were typed, e.g. . or /full/path.
+func absRoots(roots []string) ([]string, error) {
+	if !*abspathFlag && *relativeFlag == "" {
+		return roots, nil
+	}
+	abs := make([]string, len(roots))
+	for i, root := range roots {
+		var err error
+		if abs[i], err = filepath.Abs(root); err != nil {
+			return nil, err
+		}
+	}
+	return abs, nil
+}
+
+// displayPath returns path as printed: relative to the -relative base if invoked with that flag, as is otherwise.
+// Paths that can't be made relative to the base are kept absolute.
+func displayPath(path string) string {
+	if relativeBase == "" {
+		return path
+	}
+	if rel, err := filepath.Rel(relativeBase, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// relativeBase is the absolute -relative base, or "" if not invoked with that flag.
+var relativeBase string
+
+// rebaseResult replaces every path of res by its displayPath.
+func rebaseResult(res *du.Result) {
+	if relativeBase == "" {
+		return
+	}
+	for i, root := range res.Roots {
+		res.Roots[i] = displayPath(root)
+	}
+	res.PerRoot = rebaseKeys(res.PerRoot)
+	res.Dirs = rebaseKeys(res.Dirs)
+	if res.FileSizes != nil {
+		sizes := make(map[string]int64, len(res.FileSizes))
+		for path, size := range res.FileSizes {
+			sizes[displayPath(path)] = size
+		}
+		res.FileSizes = sizes
+	}
+	for _, files := range [][]du.File{res.TopFiles, res.TopDirs, res.SparseFiles} {
+		for i := range files {
+			files[i].Path = displayPath(files[i].Path)
+		}
+	}
+	for _, paths := range [][]string{res.EmptyDirs, res.EmptyFiles, res.LongPaths} {
+		for i := range paths {
+			paths[i] = displayPath(paths[i])
+		}
+	}
+	for _, g := range res.Dupes {
+		for i := range g.Paths {
+			g.Paths[i] = displayPath(g.Paths[i])
+		}
+	}
+	for i, e := range res.Errors {
+		res.Errors[i].Path = displayPath(e.Path)
+		var pe *fs.PathError
+		if errors.As(e.Err, &pe) && pe.Path == e.Path {
+			res.Errors[i].Err = &fs.PathError{Op: pe.Op, Path: res.Errors[i].Path, Err: pe.Err}
+		}
+	}
+}
+
+// rebaseKeys returns usages keyed by the displayPath of their paths, or nil if usages is nil.
+func rebaseKeys(usages map[string]*du.Usage) map[string]*du.Usage {
+	if usages == nil {
+		return nil
+	}
+	rebased := make(map[string]*du.Usage, len(usages))
+	for path, u := range usages {
+		rebased[displayPath(path)] = u
+	}
+	return rebased
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// promEscaper escapes label values in the Prometheus text exposition format.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Prints the totals of each root and the scan stats as metrics in the Prometheus text exposition format,
+// for the textfile collector of node_exporter
+func printProm(w io.Writer, res du.Result, elapsed time.Duration) {
+	perRoot := []struct {
+		name, help string
+		value      func(u *du.Usage) int64
+	}{
+		{"godu_bytes_total", "Total size of the files below the root in bytes.", func(u *du.Usage) int64 { return u.Bytes }},
+		{"godu_files_total", "Number of files below the root.", func(u *du.Usage) int64 { return u.Files }},
+		{"godu_dirs_total", "Number of directories below the root, including the root.", func(u *du.Usage) int64 { return u.Dirs }},
+	}
+	for _, m := range perRoot {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, root := range res.Roots {
+			fmt.Fprintf(w, "%s{root=\"%s\"} %d\n", m.name, promEscaper.Replace(root), m.value(res.PerRoot[root]))
+		}
+	}
+	partial := 0
+	if res.Partial {
+		partial = 1
+	}
+	if !*noTimingFlag {
+		fmt.Fprintf(w, "# HELP godu_scan_duration_seconds Duration of the scan of all the roots in seconds.\n# TYPE godu_scan_duration_seconds gauge\n")
+		fmt.Fprintf(w, "godu_scan_duration_seconds %.3f\n", elapsed.Seconds())
+	}
+	fmt.Fprintf(w, "# HELP godu_scan_errors Number of entries that couldn't be read during the scan.\n# TYPE godu_scan_errors gauge\n")
+	fmt.Fprintf(w, "godu_scan_errors %d\n", len(res.Errors))
+	fmt.Fprintf(w, "# HELP godu_scan_partial Whether the scan was interrupted before completion.\n# TYPE godu_scan_partial gauge\n")
+	fmt.Fprintf(w, "godu_scan_partial %d\n", partial)
+}
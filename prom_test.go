@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+func TestPrintProm(t *testing.T) {
+	roots := []string{`/data/back\slash`, `/data/"quoted"`, "/data/new\nline", `C:\Users\"x"`}
+	res := du.Result{Roots: roots, PerRoot: make(map[string]*du.Usage), Partial: true, Errors: []du.Error{{Path: "x"}}}
+	for i, root := range roots {
+		res.PerRoot[root] = &du.Usage{Bytes: int64(1000 * (i + 1)), Files: int64(i + 1), Dirs: 1}
+	}
+	var buf bytes.Buffer
+	printProm(&buf, res, 1500*time.Millisecond)
+	want := `# HELP godu_bytes_total Total size of the files below the root in bytes.
+# TYPE godu_bytes_total gauge
+godu_bytes_total{root="/data/back\\slash"} 1000
+godu_bytes_total{root="/data/\"quoted\""} 2000
+godu_bytes_total{root="/data/new\nline"} 3000
+godu_bytes_total{root="C:\\Users\\\"x\""} 4000
+# HELP godu_files_total Number of files below the root.
+# TYPE godu_files_total gauge
+godu_files_total{root="/data/back\\slash"} 1
+godu_files_total{root="/data/\"quoted\""} 2
+godu_files_total{root="/data/new\nline"} 3
+godu_files_total{root="C:\\Users\\\"x\""} 4
+# HELP godu_dirs_total Number of directories below the root, including the root.
+# TYPE godu_dirs_total gauge
+godu_dirs_total{root="/data/back\\slash"} 1
+godu_dirs_total{root="/data/\"quoted\""} 1
+godu_dirs_total{root="/data/new\nline"} 1
+godu_dirs_total{root="C:\\Users\\\"x\""} 1
+# HELP godu_scan_duration_seconds Duration of the scan of all the roots in seconds.
+# TYPE godu_scan_duration_seconds gauge
+godu_scan_duration_seconds 1.500
+# HELP godu_scan_errors Number of entries that couldn't be read during the scan.
+# TYPE godu_scan_errors gauge
+godu_scan_errors 1
+# HELP godu_scan_partial Whether the scan was interrupted before completion.
+# TYPE godu_scan_partial gauge
+godu_scan_partial 1
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
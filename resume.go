@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// checkpoint appends the totals of the subtrees completed by the walk to the -resume file, one JSON line each.
+type checkpoint struct {
+	f         *os.File
+	enc       *json.Encoder
+	completed map[string]du.Usage // subtrees completed by the previous runs, already in the file
+	err       error               // first write error, the walk going on without checkpoints after it
+}
+
+// openCheckpoint returns the checkpoint of path, with the subtrees completed by the previous runs read from it if it exists.
+// A last line cut short by a crash is truncated, to append the next ones after the complete lines.
+func openCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{f: f, enc: json.NewEncoder(f), completed: make(map[string]du.Usage)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var offset int64 // end of the complete lines
+	for scanner.Scan() {
+		var d dirReport
+		if json.Unmarshal(scanner.Bytes(), &d) != nil {
+			err = f.Truncate(offset)
+			break
+		}
+		c.completed[d.Path] = du.Usage{Bytes: d.Bytes, Files: d.Files, Dirs: d.Dirs, Entries: d.Entries, Symlinks: d.Symlinks}
+		offset += int64(len(scanner.Bytes())) + 1
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// add appends the totals of the completed subtree path, unless it comes from the file already.
+func (c *checkpoint) add(path string, u du.Usage) {
+	if _, ok := c.completed[path]; ok || c.err != nil {
+		return
+	}
+	c.err = c.enc.Encode(dirReport{Path: path, Bytes: u.Bytes, Files: u.Files, Dirs: u.Dirs, Entries: u.Entries, Symlinks: u.Symlinks})
+}
+
+// close closes the file, removing it if the walk is complete so that the next run starts afresh.
+func (c *checkpoint) close(complete bool) error {
+	err := c.f.Close()
+	if err == nil {
+		err = c.err
+	}
+	if complete {
+		if rerr := os.Remove(c.f.Name()); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// notifyProgress returns nil as there is no SIGUSR1 to print the progress stats on demand on this platform.
+func notifyProgress() <-chan struct{} {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyProgress returns a channel receiving a value on each SIGUSR1, to print the progress stats on demand,
+// e.g. with kill -USR1.
+func notifyProgress() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	now := make(chan struct{})
+	go func() {
+		for range sigs {
+			now <- struct{}{}
+		}
+	}()
+	return now
+}
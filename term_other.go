@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw always fails as raw terminal mode is only supported on Linux and macOS.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("the interactive browser needs a Linux or macOS terminal")
+}
+
+// termSize always reports false as the terminal size can't be queried on this platform.
+func termSize(f *os.File) (rows, cols int, ok bool) {
+	return 0, 0, false
+}
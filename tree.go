@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// treeBranches holds the connectors drawn by printTree, with box-drawing characters or in plain ASCII.
+type treeBranches struct {
+	mid, last, pipe, space string
+}
+
+var boxBranches = treeBranches{mid: "├── ", last: "└── ", pipe: "│   ", space: "    "}
+var asciiBranches = treeBranches{mid: "|-- ", last: "`-- ", pipe: "|   ", space: "    "}
+
+// Prints the directory totals as a tree indented under each root, the children of each directory in the -sort order
+func printTree(w io.Writer, res du.Result) {
+	children := make(map[string][]string)
+	for _, path := range reportedDirs(res.Dirs) {
+		if parent := filepath.Dir(path); parent != path {
+			children[parent] = append(children[parent], path)
+		}
+	}
+	branches := boxBranches
+	if *asciiFlag {
+		branches = asciiBranches
+	}
+	for _, root := range res.Roots {
+		if u := res.Dirs[root]; u != nil {
+			fmt.Fprintf(w, "%s  %s\n", dirTotal(u), root)
+			printBranch(w, res.Dirs, children, root, "", branches)
+		}
+	}
+}
+
+// Prints the children of dir and their own descendants, each line starting with prefix
+func printBranch(w io.Writer, dirs map[string]*du.Usage, children map[string][]string, dir, prefix string, branches treeBranches) {
+	for i, child := range children[dir] {
+		connector, indent := branches.mid, branches.pipe
+		if i == len(children[dir])-1 {
+			connector, indent = branches.last, branches.space
+		}
+		fmt.Fprintf(w, "%s%s%s  %s\n", prefix, connector, dirTotal(dirs[child]), filepath.Base(child))
+		printBranch(w, dirs, children, child, prefix+indent, branches)
+	}
+}
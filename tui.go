@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// tuiHelp is the key reminder shown at the bottom of the -tui browser.
+const tuiHelp = "↑/↓ or j/k: move  →/enter or l: open  ←/backspace or h: back  s: sort by size or name  q: quit"
+
+// tuiEntry is an entry listed by the -tui browser: a directory subtree or a file.
+type tuiEntry struct {
+	path string
+	size int64
+	dir  bool
+}
+
+// browser is the state of the -tui browser.
+type browser struct {
+	children map[string][]tuiEntry // entries of each directory, the roots being those of ""
+	dir      string                // directory listed, "" for the roots
+	cursor   int                   // index of the selected entry
+	top      int                   // index of the first entry shown
+	byName   bool                  // entries sorted by name instead of size
+	cursors  map[string]int        // cursor of the directories left, restored when going back to them
+}
+
+// newBrowser returns the browser of the directory and file totals of res, listing the root if there is only one.
+func newBrowser(res du.Result) *browser {
+	b := &browser{children: make(map[string][]tuiEntry), cursors: make(map[string]int)}
+	roots := make(map[string]bool)
+	for _, root := range res.Roots {
+		roots[root] = true
+	}
+	for path, u := range res.Dirs {
+		parent := filepath.Dir(path)
+		if roots[path] {
+			parent = ""
+		}
+		b.children[parent] = append(b.children[parent], tuiEntry{path: path, size: u.Bytes, dir: true})
+	}
+	for path, size := range res.FileSizes {
+		dir := filepath.Dir(path)
+		b.children[dir] = append(b.children[dir], tuiEntry{path: path, size: size})
+	}
+	if len(res.Roots) == 1 {
+		b.dir = res.Roots[0]
+	}
+	b.sort()
+	return b
+}
+
+// sort sorts the entries of every directory, largest first or by name.
+func (b *browser) sort() {
+	for _, entries := range b.children {
+		sort.Slice(entries, func(i, j int) bool {
+			if !b.byName && entries[i].size != entries[j].size {
+				return entries[i].size > entries[j].size
+			}
+			return entries[i].path < entries[j].path
+		})
+	}
+}
+
+// key applies the key pressed and reports whether the browser is still open.
+func (b *browser) key(k string) bool {
+	entries := b.children[b.dir]
+	switch k {
+	case "q", "\x03", "\x1b":
+		return false
+	case "\x1b[A", "k":
+		b.cursor--
+	case "\x1b[B", "j":
+		b.cursor++
+	case "\x1b[5~":
+		b.cursor -= 10
+	case "\x1b[6~":
+		b.cursor += 10
+	case "g", "\x1b[H":
+		b.cursor = 0
+	case "G", "\x1b[F":
+		b.cursor = len(entries) - 1
+	case "\x1b[C", "\r", "\n", "l":
+		if b.cursor < len(entries) && entries[b.cursor].dir {
+			b.cursors[b.dir] = b.cursor
+			b.dir, b.cursor, b.top = entries[b.cursor].path, 0, 0
+		}
+	case "\x1b[D", "\x7f", "\b", "h":
+		if parent := b.parent(); parent != b.dir {
+			b.cursors[b.dir] = b.cursor
+			b.dir, b.cursor, b.top = parent, b.cursors[parent], 0
+		}
+	case "s":
+		b.byName = !b.byName
+		b.sort()
+	}
+	if b.cursor >= len(b.children[b.dir]) {
+		b.cursor = len(b.children[b.dir]) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	return true
+}
+
+// parent returns the directory listing the one listed, or the listed one if it is the top.
+func (b *browser) parent() string {
+	for _, e := range b.children[""] {
+		if e.path == b.dir {
+			if len(b.children[""]) == 1 {
+				return b.dir // the only root is the top
+			}
+			return ""
+		}
+	}
+	if b.dir == "" {
+		return ""
+	}
+	return filepath.Dir(b.dir)
+}
+
+// Draws the entries of the listed directory on a screen of rows and cols, the selected one highlighted
+func (b *browser) draw(w io.Writer, rows, cols int) {
+	entries := b.children[b.dir]
+	var total, largest int64
+	for _, e := range entries {
+		total += e.size
+		if e.size > largest {
+			largest = e.size
+		}
+	}
+	title := b.dir
+	if title == "" {
+		title = "roots"
+	}
+	order := "size"
+	if b.byName {
+		order = "name"
+	}
+	fmt.Fprint(w, "\033[H\033[2J")
+	fmt.Fprint(w, clip(fmt.Sprintf("godu -- %s  %s in %d entries, by %s", title, humanize(total), len(entries), order), cols), "\n")
+	height := rows - 2
+	if height < 1 {
+		height = 1
+	}
+	if b.cursor < b.top {
+		b.top = b.cursor
+	} else if b.cursor >= b.top+height {
+		b.top = b.cursor - height + 1
+	}
+	for i := b.top; i < len(entries) && i < b.top+height; i++ {
+		e := entries[i]
+		name := filepath.Base(e.path)
+		if b.dir == "" {
+			name = e.path
+		}
+		if e.dir {
+			name += string(filepath.Separator)
+		}
+		bar := 0
+		if largest > 0 {
+			bar = int(e.size * 10 / largest)
+		}
+		line := clip(fmt.Sprintf(" %10s [%-10s] %s", humanize(e.size), strings.Repeat("#", bar), name), cols)
+		if i == b.cursor {
+			line = "\033[7m" + line + "\033[0m"
+		}
+		fmt.Fprint(w, line, "\n")
+	}
+	fmt.Fprintf(w, "\033[%d;1H%s", rows, clip(tuiHelp, cols))
+}
+
+// clip returns s cut to at most n characters.
+func clip(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// runTUI lets the user browse the directory and file totals of res on the terminal, until q is pressed.
+func runTUI(res du.Result) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("the interactive browser needs a terminal: %v", err)
+	}
+	defer tty.Close()
+	restore, err := makeRaw(tty)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	w := bufio.NewWriter(tty)
+	fmt.Fprint(w, "\033[?1049h\033[?25l") // switch to the alternate screen and hide the cursor
+	defer func() {
+		fmt.Fprint(w, "\033[?25h\033[?1049l")
+		w.Flush()
+	}()
+	b := newBrowser(res)
+	buf := make([]byte, 16)
+	for {
+		rows, cols, ok := termSize(tty)
+		if !ok {
+			rows, cols = 24, 80
+		}
+		b.draw(w, rows, cols)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		n, err := tty.Read(buf)
+		if err != nil {
+			return err
+		}
+		if !b.key(string(buf[:n])) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatSize returns bytes formatted for output, in GB unless invoked with -B, -h or -si flag
+func formatSize(bytes int64) string {
+	if unitFlag.size > 0 {
+		return unitFlag.format(bytes)
+	}
+	if *hFlag || *siFlag {
+		return humanize(bytes)
+	}
+	return fmt.Sprintf("%.1fGB", float64(bytes)/1e9)
+}
+
+// humanize returns bytes in the largest unit that keeps the value below the unit base, e.g. "1.5 MB" or "932.0 GB".
+// The base is 1024, or 1000 if invoked with -si flag.
+func humanize(bytes int64) string {
+	base := 1024.0
+	if *siFlag {
+		base = 1000
+	}
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	value := float64(bytes)
+	i := 0
+	for value >= base && i < len(units)-1 {
+		value /= base
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// unitWidth is the width the sizes of the tables are right aligned to with -B, so that they line up.
+const unitWidth = 10
+
+// unitValue is a flag holding the unit sizes are printed in, given in any form accepted by parseSize.
+type unitValue struct {
+	size  int64
+	label string // appended to the sizes, the unit suffix if given without a number, e.g. "M" for "M" but not "1048576" or "4K"
+	text  string
+}
+
+func (v *unitValue) String() string {
+	return v.text
+}
+
+func (v *unitValue) Set(s string) error {
+	unit, label := strings.TrimSpace(s), ""
+	if strings.IndexFunc(unit, func(r rune) bool { return r >= '0' && r <= '9' }) < 0 {
+		label = strings.ToUpper(unit)
+		if strings.HasSuffix(label, "IB") {
+			label = label[:len(label)-2] + "iB"
+		}
+		unit = "1" + unit
+	}
+	size, err := parseSize(unit)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return fmt.Errorf("invalid unit %q, must be positive", s)
+	}
+	*v = unitValue{size: size, label: label, text: s}
+	return nil
+}
+
+// blocks returns bytes in units, rounded up like du does.
+func (v *unitValue) blocks(bytes int64) int64 {
+	n := bytes / v.size
+	if bytes%v.size > 0 {
+		n++
+	}
+	return n
+}
+
+// format returns bytes in units followed by the unit label.
+func (v *unitValue) format(bytes int64) string {
+	return strconv.FormatInt(v.blocks(bytes), 10) + v.label
+}
+
+// header returns the name of a column of sizes in units, e.g. "1M-blocks" for M or "4096-blocks" for 4K, like df does.
+func (v *unitValue) header() string {
+	if v.label != "" {
+		return "1" + v.label + "-blocks"
+	}
+	return strconv.FormatInt(v.size, 10) + "-blocks"
+}
+
+// sizeValue is a flag holding a size in bytes, given in any form accepted by parseSize.
+type sizeValue int64
+
+func (v *sizeValue) String() string {
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+func (v *sizeValue) Set(s string) error {
+	size, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*v = sizeValue(size)
+	return nil
+}
+
+// sizeUnits maps the unit suffixes accepted by parseSize to their size in bytes. Like GNU du,
+// single letters and binary units are powers of 1024 while units ending with B are powers of 1000.
+var sizeUnits = map[string]float64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KIB": 1 << 10, "KB": 1e3,
+	"M": 1 << 20, "MIB": 1 << 20, "MB": 1e6,
+	"G": 1 << 30, "GIB": 1 << 30, "GB": 1e9,
+	"T": 1 << 40, "TIB": 1 << 40, "TB": 1e12,
+	"P": 1 << 50, "PIB": 1 << 50, "PB": 1e15,
+}
+
+// parseSize parses a size in bytes made of an optionally signed and fractional number followed by
+// an optional case insensitive unit, e.g. "4096", "500k", "1.5G", "100MB" or "-2GiB".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	size := value * unit
+	if math.Ceil(math.Abs(size)) >= math.MaxInt64 { // float64(math.MaxInt64) rounds up to 1<<63, out of range
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(math.Ceil(size)), nil
+}
+
+// parseAge parses a duration like time.ParseDuration, also accepting a number of days, weeks or years
+// with a d, w or y suffix, e.g. "30d". Years are 365 days long.
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	days := map[string]float64{"d": 1, "w": 7, "y": 365}
+	if n := len(s); n > 1 && days[s[n-1:]] > 0 {
+		value, err := strconv.ParseFloat(s[:n-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(value * days[s[n-1:]] * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatAge returns age in days if it is a whole number of days, as accepted by parseAge.
+func formatAge(age time.Duration) string {
+	if age%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", age/(24*time.Hour))
+	}
+	return age.String()
+}
+
+// agesValue is a flag holding a comma separated list of durations in any form accepted by parseAge.
+type agesValue []time.Duration
+
+func (v *agesValue) String() string {
+	ages := make([]string, len(*v))
+	for i, age := range *v {
+		ages[i] = formatAge(age)
+	}
+	return strings.Join(ages, ",")
+}
+
+func (v *agesValue) Set(s string) error {
+	var ages agesValue
+	for _, field := range strings.Split(s, ",") {
+		age, err := parseAge(field)
+		if err != nil {
+			return err
+		}
+		ages = append(ages, age)
+	}
+	*v = ages
+	return nil
+}
+
+// dateLayouts are the layouts accepted by dateValue besides RFC 3339, in local time.
+var dateLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// dateValue is a flag holding a point in time, given as an RFC 3339 time with its time zone (e.g. 2024-01-31T12:00:00Z),
+// as a date and time in local time (e.g. 2024-01-31 or 2024-01-31 12:00), or as an age in any form accepted by parseAge
+// counted back from now (e.g. 30d).
+type dateValue time.Time
+
+func (v *dateValue) String() string {
+	if time.Time(*v).IsZero() {
+		return ""
+	}
+	return time.Time(*v).Format(time.RFC3339)
+}
+
+func (v *dateValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		*v = dateValue(t)
+		return nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			*v = dateValue(t)
+			return nil
+		}
+	}
+	age, err := parseAge(s)
+	if err != nil {
+		return fmt.Errorf("invalid date or age %q", s)
+	}
+	*v = dateValue(time.Now().Add(-age))
+	return nil
+}
+
+// formatEdge returns size in the largest power of 1024 unit dividing it, as accepted by parseSize, e.g. "4K".
+func formatEdge(size int64) string {
+	units := []string{"", "K", "M", "G", "T", "P"}
+	i := 0
+	for size != 0 && size%1024 == 0 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return strconv.FormatInt(size, 10) + units[i]
+}
+
+// sizesValue is a flag holding a comma separated list of sizes in any form accepted by parseSize.
+type sizesValue []int64
+
+func (v *sizesValue) String() string {
+	sizes := make([]string, len(*v))
+	for i, size := range *v {
+		sizes[i] = formatEdge(size)
+	}
+	return strings.Join(sizes, ",")
+}
+
+func (v *sizesValue) Set(s string) error {
+	var sizes sizesValue
+	for _, field := range strings.Split(s, ",") {
+		size, err := parseSize(field)
+		if err != nil {
+			return err
+		}
+		sizes = append(sizes, size)
+	}
+	*v = sizes
+	return nil
+}
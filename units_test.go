@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want int64
+	}{
+		{"0", 0},
+		{"4096", 4096},
+		{" 4096 ", 4096},
+		{"100B", 100},
+		{"500k", 500 << 10},
+		{"500K", 500 << 10},
+		{"500KB", 500000},
+		{"1.5G", 3 << 29},
+		{"1.5g", 3 << 29},
+		{"10MiB", 10 << 20},
+		{"10mib", 10 << 20},
+		{"10MB", 10000000},
+		{"1 T", 1 << 40},
+		{"2PB", 2e15},
+		{"0.5", 1}, // rounded up to whole bytes
+		{"+3K", 3 << 10},
+		{"-2GiB", -2 << 30},
+		{"-1.5k", -1536},
+		{"8191P", 8191 << 50},
+	} {
+		got, err := parseSize(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "G", "abc", "1.2.3", "10X", "10KiBs", "5 Q", "1-2", "8192P", "9223372036854775807", "9300PB", "-9300PB", "1e30"} {
+		if got, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) = %d, want an error", s, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/robert-mcdermott/godu/du"
+)
+
+// Compares the apparent size of each root counted by godu with the one counted by the system du -sb, which also
+// counts the sizes of the directories themselves, printing the discrepancies to w, and returns the exit status:
+// 0 if all the totals match, 1 otherwise. Only the -t, -maxopen, -x and -l flags apply, with the same meaning for both.
+func verifyDU(ctx context.Context, w io.Writer, roots []string) int {
+	status := 0
+	for _, root := range roots {
+		var dirBytes int64 // sizes of the directories themselves, left out of the godu totals
+		opts := du.Options{
+			Threads:       *tFlag,
+			MaxOpen:       *maxopenFlag,
+			Apparent:      true,
+			CountLinks:    *lFlag,
+			OneFileSystem: *xFlag,
+			Visit: func(ev du.FileEvent) {
+				if ev.IsDir && ev.Err == nil {
+					if info, err := os.Lstat(ev.Path); err == nil {
+						dirBytes += info.Size()
+					}
+				}
+			},
+		}
+		res, err := du.WalkContext(ctx, []string{root}, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			return 1
+		}
+		if res.Partial {
+			fmt.Fprintf(os.Stderr, "du: %s: interrupted\n", root)
+			return 1
+		}
+		sys, err := systemDU(ctx, root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "du: %s: %v\n", root, err)
+			status = 1
+			continue
+		}
+		total := res.Bytes + dirBytes
+		verdict := "match"
+		if total != sys {
+			verdict = fmt.Sprintf("MISMATCH by %+d bytes", total-sys)
+			status = 1
+		}
+		fmt.Fprintf(w, "%s: godu %d bytes (%d in %d files, %d in %d directories), du -sb %d bytes: %s\n",
+			root, total, res.Bytes, res.Files, dirBytes, res.Directories, sys, verdict)
+		if len(res.Errors) > 0 {
+			fmt.Fprintf(w, "%s: %d entries unreadable, the totals may differ because of them\n", root, len(res.Errors))
+		}
+	}
+	return status
+}
+
+// systemDU returns the apparent size of root counted by the system du -sb, with -x and -l if invoked with those flags.
+// A du failing to read some entries still reports its total, which is returned.
+func systemDU(ctx context.Context, root string) (int64, error) {
+	args := []string{"-sb"}
+	if *xFlag {
+		args = append(args, "-x")
+	}
+	if *lFlag {
+		args = append(args, "-l")
+	}
+	out, err := exec.CommandContext(ctx, "du", append(args, "--", root)...).Output()
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no total printed by du")
+		}
+		return 0, fmt.Errorf("du -sb: %v", err)
+	}
+	total, perr := strconv.ParseInt(fields[0], 10, 64)
+	if perr != nil {
+		return 0, fmt.Errorf("du -sb: unexpected output %q", strings.TrimSpace(string(out)))
+	}
+	return total, nil
+}